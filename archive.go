@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultPurgeAge is how long deleted tasks stay in the trash before
+// purge removes them, unless told otherwise
+const defaultPurgeAge = 30 * 24 * time.Hour
+
+// archiveTask moves completed task id and its subtasks to the archive,
+// which hides them from the task list. It returns the archived tasks.
+func archiveTask(id int) ([]Task, error) {
+	task, err := getTask(id)
+	if err != nil {
+		return nil, err
+	}
+	if task.ArchivedAt != nil {
+		return nil, fmt.Errorf("task %d is already archived", id)
+	}
+	subtasks, err := subtasksOf(id)
+	if err != nil {
+		return nil, err
+	}
+	tasks := append([]Task{*task}, subtasks...)
+	for _, t := range tasks {
+		if !t.Completed {
+			return nil, fmt.Errorf("task %d is not completed; only completed tasks can be archived", t.ID)
+		}
+	}
+
+	now := time.Now()
+	var archived []Task
+	for i := range tasks {
+		if tasks[i].ArchivedAt != nil {
+			continue
+		}
+		tasks[i].ArchivedAt = &now
+		if err := updateTask(&tasks[i]); err != nil {
+			return nil, err
+		}
+		archived = append(archived, tasks[i])
+	}
+	return archived, nil
+}
+
+// archiveCompleted archives every completed task whose subtasks are all
+// completed too, and returns the archived tasks
+func archiveCompleted() ([]Task, error) {
+	all, err := allTasks()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var archived []Task
+	for i := range all {
+		task := &all[i]
+		if !task.Completed || task.ArchivedAt != nil {
+			continue
+		}
+		done := true
+		for _, sub := range descendants(all, task.ID) {
+			done = done && sub.Completed
+		}
+		if !done {
+			continue
+		}
+		task.ArchivedAt = &now
+		if err := updateTask(task); err != nil {
+			return nil, err
+		}
+		archived = append(archived, *task)
+	}
+	return archived, nil
+}
+
+// unarchiveTask brings archived task id and its archived subtasks back
+// to the task list, and returns them
+func unarchiveTask(id int) ([]Task, error) {
+	task, err := getTask(id)
+	if err != nil {
+		return nil, err
+	}
+	if task.ArchivedAt == nil {
+		return nil, fmt.Errorf("task %d is not archived", id)
+	}
+	subtasks, err := subtasksOf(id)
+	if err != nil {
+		return nil, err
+	}
+	var restored []Task
+	for _, t := range append([]Task{*task}, subtasks...) {
+		if t.ArchivedAt == nil {
+			continue
+		}
+		t.ArchivedAt = nil
+		if err := updateTask(&t); err != nil {
+			return nil, err
+		}
+		restored = append(restored, t)
+	}
+	return restored, nil
+}
+
+// trashTask moves task to the trash at now, stopping its timer
+func trashTask(task *Task, now time.Time) error {
+	if task.Running() {
+		stopEntry(task, now)
+	}
+	task.DeletedAt = &now
+	return updateTask(task)
+}
+
+// trashedTasks returns the tasks in the trash, ordered by ID
+func trashedTasks() ([]Task, error) {
+	tasks, err := store.List(ListOptions{Archived: true, Trashed: true})
+	if err != nil {
+		return nil, err
+	}
+	var trashed []Task
+	for _, task := range tasks {
+		if task.DeletedAt != nil {
+			trashed = append(trashed, task)
+		}
+	}
+	return trashed, nil
+}
+
+// restoreTask takes task id out of the trash, together with the
+// subtasks that were deleted with it, and returns the restored tasks. A
+// task whose parent is still in the trash becomes a top-level task.
+func restoreTask(id int) ([]Task, error) {
+	trashed, err := trashedTasks()
+	if err != nil {
+		return nil, err
+	}
+	index := taskIndex(trashed)
+	task, ok := index[id]
+	if !ok {
+		if _, err := getTask(id); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("task %d is not in the trash", id)
+	}
+
+	if _, ok := index[task.ParentID]; ok {
+		task.ParentID = 0
+	}
+	tasks := []Task{task}
+	for _, sub := range descendants(trashed, id) {
+		if sub.DeletedAt.Equal(*task.DeletedAt) {
+			tasks = append(tasks, sub)
+		}
+	}
+	for i := range tasks {
+		tasks[i].DeletedAt = nil
+		if err := updateTask(&tasks[i]); err != nil {
+			return nil, err
+		}
+	}
+	return tasks, nil
+}
+
+// purgeTrash permanently deletes the tasks that have been in the trash
+// for at least age, and returns them
+func purgeTrash(age time.Duration, now time.Time) ([]Task, error) {
+	trashed, err := trashedTasks()
+	if err != nil {
+		return nil, err
+	}
+	var purged []Task
+	for _, task := range trashed {
+		if now.Sub(*task.DeletedAt) < age {
+			continue
+		}
+		if err := removeTask(task.ID); err != nil {
+			return nil, err
+		}
+		purged = append(purged, task)
+	}
+	return purged, nil
+}
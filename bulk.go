@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// idListPattern matches selections made of task IDs and ranges, such as
+// "3,5,8-12"; anything else is read as a filter expression
+var idListPattern = regexp.MustCompile(`^[0-9][0-9,\s-]*$`)
+
+// missingTasksError is returned when selected task IDs do not exist
+type missingTasksError struct {
+	ids []int
+}
+
+func (e missingTasksError) Error() string {
+	if len(e.ids) == 1 {
+		return fmt.Sprintf("task %d not found", e.ids[0])
+	}
+	return fmt.Sprintf("tasks %s not found", formatIDs(e.ids))
+}
+
+// singleID reports whether expr selects exactly one task by its ID.
+// Commands given a single ID behave as they did before selections.
+func singleID(expr string) (int, bool) {
+	id, err := strconv.Atoi(strings.TrimSpace(expr))
+	return id, err == nil
+}
+
+// selectTasks returns the tasks of the current project that expr
+// selects, ordered by ID. expr is either a list of task IDs and ranges,
+// such as "3,5,8-12" or "3 5 8-12", every one of which must exist, or
+// a filter expression, which only selects tasks that are not archived.
+func selectTasks(expr string) ([]Task, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return nil, fmt.Errorf("no tasks selected")
+	}
+	if !idListPattern.MatchString(expr) {
+		filter, err := ParseFilter(expr)
+		if err != nil {
+			return nil, err
+		}
+		return store.List(ListOptions{Filter: filter})
+	}
+
+	ids, err := parseIDList(strings.Join(strings.Fields(expr), ","))
+	if err != nil {
+		return nil, err
+	}
+	var tasks []Task
+	var missing []int
+	for _, id := range ids {
+		task, err := getTask(id)
+		if err == errTaskNotFound {
+			missing = append(missing, id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, *task)
+	}
+	if len(missing) > 0 {
+		return nil, missingTasksError{missing}
+	}
+	return tasks, nil
+}
+
+// taskIDs returns the IDs of tasks
+func taskIDs(tasks []Task) []int {
+	ids := make([]int, len(tasks))
+	for i, task := range tasks {
+		ids[i] = task.ID
+	}
+	return ids
+}
+
+// printSelection lists the tasks a bulk change is about to affect
+func printSelection(w io.Writer, tasks []Task, now time.Time) {
+	for _, task := range tasks {
+		printTaskLine(w, treeNode{Task: task}, now)
+	}
+}
+
+// askSelection shows tasks and asks whether to verb all of them
+func askSelection(verb string, tasks []Task) (bool, error) {
+	fmt.Printf("%d task(s) selected:\n", len(tasks))
+	printSelection(os.Stdout, tasks, time.Now())
+	return input.Confirm(fmt.Sprintf("%s %d task(s)? (y/N): ", verb, len(tasks)))
+}
+
+// atomically runs fn so that the changes it makes to the tasks of the
+// current project are either all kept or, when it fails, all rolled
+// back, together with their undo history
+func atomically(fn func() error) error {
+	return atomicallyIn(currentProject, fn)
+}
+
+// atomicallyIn is atomically for the tasks of project
+func atomicallyIn(project string, fn func() error) error {
+	saved := store
+	if project != currentProject {
+		var err error
+		if saved, err = projectStore(project); err != nil {
+			return err
+		}
+	}
+	use := func(s TaskStore) {
+		if project == currentProject {
+			store = s
+		}
+		openStores[project] = s
+	}
+	mark := history.mark()
+	err := saved.Atomic(func(tx TaskStore) error {
+		use(tx)
+		return fn()
+	})
+	use(saved)
+	if err != nil {
+		history.discard(mark)
+	}
+	return err
+}
+
+// unselectedSubtasks returns the subtasks of tasks that are not among
+// tasks themselves
+func unselectedSubtasks(tasks []Task) ([]Task, error) {
+	all, err := allTasks()
+	if err != nil {
+		return nil, err
+	}
+	seen := map[int]bool{}
+	for _, task := range tasks {
+		seen[task.ID] = true
+	}
+	var result []Task
+	for _, task := range tasks {
+		for _, sub := range descendants(all, task.ID) {
+			if !seen[sub.ID] {
+				seen[sub.ID] = true
+				result = append(result, sub)
+			}
+		}
+	}
+	return result, nil
+}
+
+// openBlockersOutside returns, for each of tasks still waiting for
+// other tasks, the open blockers that are not among tasks themselves
+func openBlockersOutside(tasks []Task) (map[int][]int, error) {
+	all, err := allTasks()
+	if err != nil {
+		return nil, err
+	}
+	index := taskIndex(all)
+	selected := map[int]bool{}
+	for _, task := range tasks {
+		selected[task.ID] = true
+	}
+	blocked := map[int][]int{}
+	for _, task := range tasks {
+		for _, blocker := range openBlockers(index, task) {
+			if !selected[blocker] {
+				blocked[task.ID] = append(blocked[task.ID], blocker)
+			}
+		}
+	}
+	return blocked, nil
+}
+
+// completeSelected completes tasks, and with cascade all their open
+// subtasks, in one step. Tasks that are already completed are skipped.
+// It returns the completed tasks and the next occurrences of recurring
+// ones.
+func completeSelected(tasks []Task, cascade bool) (completed, next []Task, err error) {
+	err = atomically(func() error {
+		for _, selected := range tasks {
+			task, err := getTask(selected.ID)
+			if err != nil {
+				return err
+			}
+			if task.Completed {
+				continue
+			}
+			task, n, err := markCompleted(task.ID, cascade)
+			if err != nil {
+				return err
+			}
+			completed = append(completed, *task)
+			if n != nil {
+				next = append(next, *n)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return completed, next, nil
+}
+
+// deleteSelected moves tasks to the trash in one step. Subtasks that are
+// not selected themselves are only deleted with cascade; without it
+// such subtasks make the whole deletion fail.
+func deleteSelected(tasks []Task, cascade bool) error {
+	outside, err := unselectedSubtasks(tasks)
+	if err != nil {
+		return err
+	}
+	if len(outside) > 0 && !cascade {
+		return fmt.Errorf("the selected tasks have %d subtask(s) that are not selected (%s); delete them too or move them first",
+			len(outside), formatIDs(taskIDs(outside)))
+	}
+	return atomically(func() error {
+		for _, task := range tasks {
+			// A task deleted along with its parent is already gone
+			if _, err := getTask(task.ID); err == errTaskNotFound {
+				continue
+			}
+			if err := deleteWithSubtasks(task.ID, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// tagSelected adds and removes tags on tasks in one step and returns
+// the updated tasks
+func tagSelected(tasks []Task, add, remove []string) ([]Task, error) {
+	var updated []Task
+	err := atomically(func() error {
+		for _, selected := range tasks {
+			task, err := getTask(selected.ID)
+			if err != nil {
+				return err
+			}
+			task.AddTags(add...)
+			task.RemoveTags(remove...)
+			if err := updateTask(task); err != nil {
+				return err
+			}
+			updated = append(updated, *task)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// editedTask is a task changed by a bulk edit, with what changed
+type editedTask struct {
+	Task    *Task         `json:"task"`
+	Changes []fieldChange `json:"changes"`
+}
+
+// editSelected applies edit to each of tasks in one step. Tasks that
+// edit leaves unchanged are reported with no changes and not saved.
+func editSelected(tasks []Task, edit func(task *Task) error) ([]editedTask, error) {
+	var edited []editedTask
+	err := atomically(func() error {
+		for _, selected := range tasks {
+			old, err := getTask(selected.ID)
+			if err != nil {
+				return err
+			}
+			task := snapshot(old)
+			if err := edit(task); err != nil {
+				return fmt.Errorf("task %d: %v", task.ID, err)
+			}
+			changes := diffTasks(*old, *task)
+			if len(changes) > 0 {
+				if err := updateTask(task); err != nil {
+					return err
+				}
+			} else {
+				changes = []fieldChange{}
+			}
+			edited = append(edited, editedTask{task, changes})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return edited, nil
+}
@@ -0,0 +1,1828 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exit codes returned by the subcommands
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitNotFound = 3
+)
+
+// usageText describes the subcommands and is printed by "help" and -h
+const usageText = `Usage: %s [-file path | -db path] [-project name] <command> [arguments]
+
+Commands:
+  add --title T [--desc D] [--priority P] [--due DATE] [--tags a,b]
+      [--parent ID] [--repeat RULE] [--blocked-by ID,ID]
+                      add a task, optionally as a subtask of another
+  list [--sort id|priority|due|deps] [--filter EXPR] [--archived] [--all]
+      [--board]
+                      list tasks as a tree, optionally only those
+                      matching EXPR; deps lists every task after the
+                      tasks blocking it, --all lists every project and
+                      --board shows a column per workflow state
+  done <tasks> [--cascade] [--yes]
+                      mark tasks (and with --cascade their subtasks)
+                      as completed
+  state <tasks> [<state>] [--yes]
+                      move tasks to another workflow state, or show the
+                      state of a task and where it can move
+  workflow            show the workflow states and the allowed moves
+  rm <tasks> [--cascade] [--yes]
+                      move tasks to the trash; a task with subtasks is
+                      only deleted with --cascade, which deletes them too
+  archive [<id>]      hide a completed task and its subtasks from the
+                      list, or without an ID every completed task;
+                      archived tasks are still found by search
+  unarchive <id>      bring an archived task back to the list
+  trash               list the tasks in the trash
+  restore <id>        take a task out of the trash, with the subtasks
+                      deleted along with it
+  purge [--older-than DURATION]
+                      permanently delete the tasks that have been in the
+                      trash for at least DURATION (default 30d)
+  search <words>...   find tasks whose title or description has words
+                      starting with every given word, best match first
+  history <id>        show who changed a task and when, from its
+                      journal, including while it is in the trash
+  compact             fold the journal of the current project into its
+                      JSON file; changes from before are no longer
+                      listed by history
+  tag <tasks> <tag>... [--yes]
+                      add tags to tasks
+  untag <tasks> <tag>... [--yes]
+                      remove tags from tasks
+  block <id> <blocker-id>...
+                      make a task wait for other tasks; links that
+                      would create a cycle are refused
+  unblock <id> <blocker-id>...
+                      remove blocking tasks from a task
+  edit <tasks> [--title T] [--desc D] [--priority P] [--due DATE]
+      [--parent ID] [--repeat RULE] [--yes]
+                      change tasks; --due "" clears the due date,
+                      --parent 0 makes them top-level tasks and
+                      --repeat "" stops them repeating; --title only
+                      changes one task at a time
+  export [--format csv|md|ics|todotxt] [--out FILE] [--filter EXPR]
+                      write tasks as CSV, a Markdown checklist,
+                      iCalendar to-dos or todo.txt; the format defaults
+                      to the file extension (.txt is todo.txt), the
+                      file to standard output
+  import [--format csv|md|todotxt] FILE
+                      add the tasks from a CSV file, Markdown checklist
+                      or todo.txt file with fresh IDs; todo.txt tokens
+                      the tasks have no field for are kept and written
+                      back on export
+  project [list]      list the projects; * marks the current one
+  project create|switch <name>
+                      add a project, or make it the current one
+  project rename <old> <new>
+                      rename a project
+  project delete <name> [--force]
+                      delete a project; one that has tasks is only
+                      deleted with --force, which deletes them too
+  move <id>... --to NAME
+                      move tasks with their subtasks to another project,
+                      where they get new IDs
+  start <id>          start tracking time on a task; only one timer
+                      runs at a time, across all projects
+  stop [<id>]         stop the running timer
+  timesheet [--from DATE] [--to DATE]
+                      report the time tracked per day and per task,
+                      archived and trashed ones included, by default
+                      for the current week
+  report [--from DATE] [--to DATE]
+                      show the completion rate, average time to
+                      complete, tasks completed per week and a burndown
+                      chart of open tasks, by default for four weeks
+  remind [--watch]    send the reminders that are due now, or with
+                      --watch keep sending them until interrupted
+  snooze <id> [--for DURATION]
+                      put off the reminders for a task (default 15m)
+  undo                revert the last change
+  redo                apply the last undone change again
+  interactive [--menu]
+                      start the full-screen task list, or the numbered
+                      menu with --menu or when not run in a terminal
+                      (default)
+  help                show this message
+
+<tasks> is a task ID, a list of IDs and ranges such as 3,5,8-12, or a
+filter expression, which selects the matching tasks that are not
+archived. Unless it is a single ID, the selected tasks are listed and the
+command asks before changing them, or goes ahead with --yes; the tasks
+are then changed all together or, if one fails, not at all.
+
+Priorities are low, medium, high and urgent. Due dates are written as
+YYYY-MM-DD, "YYYY-MM-DD HH:MM" or in words, like "tomorrow 5pm", "next
+friday", "in 3 days", "end of month" or "jun 14"; without a time of day
+a task is due at the end of the day. A filter is a list of terms that must
+all hold, such as "tag:infra status:pending -tag:blocked"; terms are
+tag:NAME, status:pending|completed|overdue, priority:P, state:NAME or
+plain text, and a leading '-' negates a term. Repeat rules are daily, weekly,
+weekly:mon,thu, monthly:15 or "every 3 days"; completing a repeating task
+adds its next occurrence.
+
+Tasks move through the states of a workflow, by default todo and done.
+A JSON file next to the tasks (tasks.json.workflow) can define others:
+{"states": ["todo", "doing", "review", "done"], "done": ["done"],
+"transitions": {"todo": ["doing"], "doing": ["review", "todo"], "review":
+["done", "doing"], "done": ["todo"]}}. New tasks start in the first
+state, tasks in a done state count as completed, and without
+"transitions" any move is allowed. "done" moves tasks to the first done
+state and is refused where the workflow does not allow that move.
+
+Reminders are sent at the -remind lead times before a task is due, by
+the interactive modes and by "remind". They ring the terminal bell and
+print a message, or run the -notify command with TASK_ID, TASK_TITLE,
+TASK_DUE, TASK_PROJECT and TASK_MESSAGE set. Durations are written like
+30m, 2h or 1d.
+
+Every command except export, undo, redo, interactive and help accepts
+--json to print machine-readable output; export writes the formats
+above instead. Each command's changes can be undone as one step, also
+after a restart.
+
+Changes to the tasks in a JSON file are appended as events to a journal
+next to it (tasks.json.journal), which is replayed on top of the file at
+startup; the database records them in a table of its own.
+
+Global flags:
+`
+
+// usage prints the command overview followed by the global flags
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), usageText, os.Args[0])
+	flag.PrintDefaults()
+}
+
+// runCommand executes the subcommand named by args[0] and returns the
+// process exit code. Without arguments the interactive mode is started.
+func runCommand(args []string) int {
+	if len(args) == 0 {
+		return cmdInteractive(nil)
+	}
+
+	name, args := args[0], args[1:]
+	switch name {
+	case "undo", "redo", "interactive", "help":
+	default:
+		history.Begin(name)
+		defer func() {
+			if err := history.End(); err != nil {
+				fmt.Fprintln(os.Stderr, "Warning:", err)
+			}
+		}()
+	}
+
+	switch name {
+	case "add":
+		return cmdAdd(args)
+	case "list":
+		return cmdList(args)
+	case "done":
+		return cmdDone(args)
+	case "state":
+		return cmdState(args)
+	case "workflow":
+		return cmdWorkflow(args)
+	case "rm":
+		return cmdRemove(args)
+	case "edit":
+		return cmdEdit(args)
+	case "block":
+		return cmdBlock("block", args)
+	case "unblock":
+		return cmdBlock("unblock", args)
+	case "search":
+		return cmdSearch(args)
+	case "history":
+		return cmdHistory(args)
+	case "compact":
+		return cmdCompact(args)
+	case "archive":
+		return cmdArchive(args)
+	case "unarchive":
+		return cmdUnarchive(args)
+	case "trash":
+		return cmdTrash(args)
+	case "restore":
+		return cmdRestore(args)
+	case "purge":
+		return cmdPurge(args)
+	case "project":
+		return cmdProject(args)
+	case "move":
+		return cmdMove(args)
+	case "tag":
+		return cmdTag("tag", args)
+	case "untag":
+		return cmdTag("untag", args)
+	case "export":
+		return cmdExport(args)
+	case "import":
+		return cmdImport(args)
+	case "undo":
+		return cmdUndo("undo", args, history.UndoLast, "Undone")
+	case "redo":
+		return cmdUndo("redo", args, history.RedoLast, "Redone")
+	case "start":
+		return cmdStart(args)
+	case "stop":
+		return cmdStop(args)
+	case "timesheet":
+		return cmdTimesheet(args)
+	case "report":
+		return cmdReport(args)
+	case "remind":
+		return cmdRemind(args)
+	case "snooze":
+		return cmdSnooze(args)
+	case "interactive":
+		return cmdInteractive(args)
+	case "help":
+		flag.CommandLine.SetOutput(os.Stdout)
+		usage()
+		return exitOK
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown command %q\n", name)
+		usage()
+		return exitUsage
+	}
+}
+
+// parseFlags parses args with fs, allowing flags to appear after
+// positional arguments (e.g. "done 3 --json"), and returns the positionals.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// newFlagSet creates a flag set for a subcommand that reports errors
+// instead of exiting
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+// parseID parses the single task ID a subcommand expects
+func parseID(name string, positional []string) (int, error) {
+	if len(positional) != 1 {
+		return 0, fmt.Errorf("%s expects exactly one task ID", name)
+	}
+	id, err := strconv.Atoi(positional[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid task ID %q", positional[0])
+	}
+	return id, nil
+}
+
+// failure prints err and maps it to an exit code
+func failure(err error) int {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	switch err.(type) {
+	case projectNotFoundError, missingTasksError:
+		return exitNotFound
+	}
+	if err == errTaskNotFound {
+		return exitNotFound
+	}
+	return exitError
+}
+
+// flagFailure maps a flag parsing error to an exit code; the flag
+// package has already printed the message or the help text
+func flagFailure(err error) int {
+	if err == flag.ErrHelp {
+		return exitOK
+	}
+	return exitUsage
+}
+
+// usageFailure prints a usage error and returns exitUsage
+func usageFailure(err error) int {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	return exitUsage
+}
+
+// printJSON writes v as indented JSON to stdout
+func printJSON(v interface{}) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return failure(err)
+	}
+	return exitOK
+}
+
+// confirmSelection asks whether a bulk command should verb the selected
+// tasks, unless yes is set. The question would spoil JSON output, so
+// with asJSON the answer must be given with yes.
+func confirmSelection(verb string, tasks []Task, yes, asJSON bool) (bool, error) {
+	if yes {
+		return true, nil
+	}
+	if asJSON {
+		return false, fmt.Errorf("--json with a selection of tasks needs --yes")
+	}
+	ok, err := askSelection(verb, tasks)
+	if err != nil {
+		return false, fmt.Errorf("no answer; use --yes to %s the tasks without asking", strings.ToLower(verb))
+	}
+	return ok, nil
+}
+
+// notConfirmed reports a bulk command the user did not go ahead with
+func notConfirmed(err error) int {
+	if err != nil {
+		return failure(err)
+	}
+	fmt.Println("Nothing changed")
+	return exitError
+}
+
+// printTaskLine writes a one-line summary of a task, indented by its
+// depth in the subtask tree
+func printTaskLine(w io.Writer, node treeNode, now time.Time) {
+	task := node.Task
+	mark := " "
+	if task.Completed {
+		mark = "x"
+	}
+	due := ""
+	if task.DueDate != nil {
+		due = "  due " + formatDueDate(task.DueDate)
+		if task.IsOverdue(now) {
+			due = colorize(ansiRed, due+" OVERDUE")
+		}
+	}
+	tags := ""
+	for _, tag := range task.Tags {
+		tags += " #" + tag
+	}
+	rollUp := ""
+	if node.Total > 0 {
+		rollUp = " (" + node.rollUp() + ")"
+	}
+	blocked := ""
+	if len(node.Blockers) > 0 {
+		blocked = colorize(ansiYellow, "  BLOCKED by "+formatIDs(node.Blockers))
+	}
+	tracked := ""
+	if len(task.TimeEntries) > 0 {
+		tracked = "  tracked " + formatTracked(task.Tracked(now))
+		if task.Running() {
+			tracked = colorize(ansiYellow, tracked+" RUNNING")
+		}
+	}
+	archived := ""
+	if task.ArchivedAt != nil {
+		archived = "  ARCHIVED"
+	}
+	indent := strings.Repeat("  ", node.Depth)
+	fmt.Fprintf(w, "%4d  [%s] %-6s  %s%s%s%s%s%s%s%s\n", task.ID, mark, task.Priority,
+		indent, task.Title, tags, rollUp, blocked, due, tracked, archived)
+}
+
+func cmdAdd(args []string) int {
+	fs := newFlagSet("add")
+	title := fs.String("title", "", "task title (required)")
+	desc := fs.String("desc", "", "task description")
+	priority := fs.String("priority", "medium", "low, medium, high or urgent")
+	due := fs.String("due", "", "due date")
+	tags := fs.String("tags", "", "comma separated tags")
+	parent := fs.Int("parent", 0, "ID of the parent task")
+	repeat := fs.String("repeat", "", "recurrence rule")
+	blockedBy := fs.String("blocked-by", "", "comma separated IDs of tasks that must be done first")
+	asJSON := fs.Bool("json", false, "print the new task as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	if len(positional) > 0 {
+		return usageFailure(fmt.Errorf("add takes no positional arguments"))
+	}
+	if *title == "" {
+		return usageFailure(fmt.Errorf("--title is required"))
+	}
+
+	task := Task{Title: *title, Description: *desc, Tags: parseTagList(*tags), ParentID: *parent}
+	if task.Priority, err = ParsePriority(*priority); err != nil {
+		return usageFailure(err)
+	}
+	if *due != "" {
+		dueDate, err := parseDueDate(*due)
+		if err != nil {
+			return usageFailure(err)
+		}
+		task.DueDate = &dueDate
+	}
+	if *repeat != "" {
+		if task.Recurrence, err = ParseRecurrence(*repeat); err != nil {
+			return usageFailure(err)
+		}
+	}
+	if task.BlockedBy, err = parseIDList(*blockedBy); err != nil {
+		return usageFailure(err)
+	}
+	if task.ParentID != 0 || len(task.BlockedBy) > 0 {
+		all, err := allTasks()
+		if err != nil {
+			return failure(err)
+		}
+		if err := validateParent(all, 0, task.ParentID); err != nil {
+			return failure(err)
+		}
+		if err := validateBlockers(all, 0, task.BlockedBy); err != nil {
+			return failure(err)
+		}
+	}
+	if err := createTask(&task); err != nil {
+		return failure(err)
+	}
+
+	if *asJSON {
+		return printJSON(task)
+	}
+	fmt.Printf("Added task %d\n", task.ID)
+	if task.DueDate != nil {
+		fmt.Printf("Due %s\n", describeDate(*task.DueDate))
+	}
+	return exitOK
+}
+
+func cmdList(args []string) int {
+	fs := newFlagSet("list")
+	order := fs.String("sort", "id", "sort by id, priority, due or deps")
+	expr := fs.String("filter", "", "only list tasks matching this filter expression")
+	everyProject := fs.Bool("all", false, "list the tasks of every project")
+	archived := fs.Bool("archived", false, "also list archived tasks")
+	board := fs.Bool("board", false, "show the tasks as a board with a column per workflow state")
+	asJSON := fs.Bool("json", false, "print tasks as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	if len(positional) > 0 {
+		return usageFailure(fmt.Errorf("list takes no positional arguments"))
+	}
+	if *board && *everyProject {
+		return usageFailure(fmt.Errorf("--board shows one project at a time and cannot be combined with --all"))
+	}
+
+	filter, err := ParseFilter(*expr)
+	if err != nil {
+		return usageFailure(err)
+	}
+
+	if _, err := sortOrder(*order); err != nil {
+		return usageFailure(err)
+	}
+
+	now := time.Now()
+	opts := ListOptions{Filter: filter, Sort: *order, Now: now, Archived: *archived}
+	if *everyProject {
+		groups, err := listAllProjects(opts)
+		if err != nil {
+			return failure(err)
+		}
+		if *asJSON {
+			return printJSON(groups)
+		}
+		for i, group := range groups {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%s (%d)\n", group.Project, len(group.Tasks))
+			for _, node := range group.nodes {
+				printTaskLine(os.Stdout, node, now)
+			}
+		}
+		return exitOK
+	}
+
+	tasks, err := store.List(opts)
+	if err != nil {
+		return failure(err)
+	}
+
+	if *board {
+		columns := boardColumns(tasks)
+		if *asJSON {
+			return printJSON(columns)
+		}
+		_, width := terminalSize()
+		printBoard(os.Stdout, columns, width, now)
+		return exitOK
+	}
+	if *asJSON {
+		if tasks == nil {
+			tasks = []Task{}
+		}
+		return printJSON(tasks)
+	}
+	nodes, err := layoutTasks(tasks, *order)
+	if err != nil {
+		return failure(err)
+	}
+	for _, node := range nodes {
+		printTaskLine(os.Stdout, node, now)
+	}
+	return exitOK
+}
+
+func cmdDone(args []string) int {
+	fs := newFlagSet("done")
+	cascade := fs.Bool("cascade", false, "also complete all open subtasks")
+	yes := fs.Bool("yes", false, "complete a selection of tasks without asking")
+	asJSON := fs.Bool("json", false, "print the completed task as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	if len(positional) == 0 {
+		return usageFailure(fmt.Errorf("done expects a task ID, a list of IDs or a filter"))
+	}
+	expr := strings.Join(positional, " ")
+	id, ok := singleID(expr)
+	if !ok {
+		return doneSelection(expr, *cascade, *yes, *asJSON)
+	}
+
+	blockers, err := blockersOf(id)
+	if err != nil {
+		return failure(err)
+	}
+	task, next, err := markCompleted(id, *cascade)
+	if err != nil {
+		return failure(err)
+	}
+	if len(blockers) > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: task %d is still blocked by %s\n", id, formatIDs(blockers))
+	}
+
+	if *asJSON {
+		return printJSON(struct {
+			Task *Task `json:"task"`
+			Next *Task `json:"next,omitempty"`
+		}{task, next})
+	}
+	fmt.Printf("Completed task %d\n", task.ID)
+	if next != nil {
+		fmt.Printf("Added next occurrence as task %d, due %s\n", next.ID, formatDueDate(next.DueDate))
+	}
+	return exitOK
+}
+
+// doneSelection completes the tasks selected by expr
+func doneSelection(expr string, cascade, yes, asJSON bool) int {
+	tasks, err := selectTasks(expr)
+	if err != nil {
+		return failure(err)
+	}
+	if len(tasks) > 0 {
+		if ok, err := confirmSelection("Complete", tasks, yes, asJSON); err != nil || !ok {
+			return notConfirmed(err)
+		}
+	}
+	blocked, err := openBlockersOutside(tasks)
+	if err != nil {
+		return failure(err)
+	}
+	completed, next, err := completeSelected(tasks, cascade)
+	if err != nil {
+		return failure(err)
+	}
+	for _, task := range completed {
+		if blockers := blocked[task.ID]; len(blockers) > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: task %d is still blocked by %s\n", task.ID, formatIDs(blockers))
+		}
+	}
+
+	if asJSON {
+		if completed == nil {
+			completed = []Task{}
+		}
+		if next == nil {
+			next = []Task{}
+		}
+		return printJSON(struct {
+			Tasks []Task `json:"tasks"`
+			Next  []Task `json:"next"`
+		}{completed, next})
+	}
+	if len(completed) == 0 {
+		fmt.Println("No tasks to complete")
+		return exitOK
+	}
+	fmt.Printf("Completed tasks %s\n", formatIDs(taskIDs(completed)))
+	for _, n := range next {
+		fmt.Printf("Added next occurrence as task %d, due %s\n", n.ID, formatDueDate(n.DueDate))
+	}
+	return exitOK
+}
+
+func cmdState(args []string) int {
+	fs := newFlagSet("state")
+	yes := fs.Bool("yes", false, "move a selection of tasks without asking")
+	asJSON := fs.Bool("json", false, "print the moved task as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	if len(positional) == 0 || len(positional) > 2 {
+		return usageFailure(fmt.Errorf("state expects a task ID, a list of IDs or a filter, and the state to move to"))
+	}
+	id, single := singleID(positional[0])
+	if len(positional) == 1 {
+		if !single {
+			return usageFailure(fmt.Errorf("state without a state to move to expects a single task ID"))
+		}
+		return showState(id, *asJSON)
+	}
+	to := strings.ToLower(positional[1])
+	if !single {
+		return stateSelection(positional[0], to, *yes, *asJSON)
+	}
+
+	task, next, err := changeState(id, to)
+	if err != nil {
+		return failure(err)
+	}
+
+	if *asJSON {
+		return printJSON(struct {
+			Task *Task `json:"task"`
+			Next *Task `json:"next,omitempty"`
+		}{task, next})
+	}
+	fmt.Printf("Moved task %d to %s\n", task.ID, to)
+	if next != nil {
+		fmt.Printf("Added next occurrence as task %d, due %s\n", next.ID, formatDueDate(next.DueDate))
+	}
+	return exitOK
+}
+
+// showState prints the state of task id and where it can move from there
+func showState(id int, asJSON bool) int {
+	task, err := getTask(id)
+	if err != nil {
+		return failure(err)
+	}
+	state := workflow.StateOf(*task)
+	next := workflow.Next(state)
+	if asJSON {
+		if next == nil {
+			next = []string{}
+		}
+		return printJSON(struct {
+			ID    int      `json:"id"`
+			State string   `json:"state"`
+			Next  []string `json:"next"`
+		}{task.ID, state, next})
+	}
+	if len(next) == 0 {
+		fmt.Printf("Task %d is %s, which is final\n", task.ID, state)
+		return exitOK
+	}
+	fmt.Printf("Task %d is %s and can move to %s\n", task.ID, state, strings.Join(next, ", "))
+	return exitOK
+}
+
+// stateSelection moves the tasks selected by expr to state to
+func stateSelection(expr, to string, yes, asJSON bool) int {
+	if !workflow.Has(to) {
+		return usageFailure(fmt.Errorf("unknown state %q (use %s)", to, strings.Join(workflow.States, ", ")))
+	}
+	tasks, err := selectTasks(expr)
+	if err != nil {
+		return failure(err)
+	}
+	if len(tasks) > 0 {
+		if ok, err := confirmSelection("Move", tasks, yes, asJSON); err != nil || !ok {
+			return notConfirmed(err)
+		}
+	}
+	moved, next, err := changeSelectedState(tasks, to)
+	if err != nil {
+		return failure(err)
+	}
+
+	if asJSON {
+		if moved == nil {
+			moved = []Task{}
+		}
+		if next == nil {
+			next = []Task{}
+		}
+		return printJSON(struct {
+			Tasks []Task `json:"tasks"`
+			Next  []Task `json:"next"`
+		}{moved, next})
+	}
+	if len(moved) == 0 {
+		fmt.Println("No tasks to move")
+		return exitOK
+	}
+	fmt.Printf("Moved tasks %s to %s\n", formatIDs(taskIDs(moved)), to)
+	for _, n := range next {
+		fmt.Printf("Added next occurrence as task %d, due %s\n", n.ID, formatDueDate(n.DueDate))
+	}
+	return exitOK
+}
+
+func cmdWorkflow(args []string) int {
+	fs := newFlagSet("workflow")
+	asJSON := fs.Bool("json", false, "print the workflow as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	if len(positional) > 0 {
+		return usageFailure(fmt.Errorf("workflow takes no arguments"))
+	}
+	if *asJSON {
+		return printJSON(workflow)
+	}
+	printWorkflow(os.Stdout)
+	return exitOK
+}
+
+func cmdRemove(args []string) int {
+	fs := newFlagSet("rm")
+	cascade := fs.Bool("cascade", false, "also delete all subtasks")
+	yes := fs.Bool("yes", false, "delete a selection of tasks without asking")
+	asJSON := fs.Bool("json", false, "print the deleted task as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	if len(positional) == 0 {
+		return usageFailure(fmt.Errorf("rm expects a task ID, a list of IDs or a filter"))
+	}
+	expr := strings.Join(positional, " ")
+	id, ok := singleID(expr)
+	if !ok {
+		return removeSelection(expr, *cascade, *yes, *asJSON)
+	}
+
+	task, err := getTask(id)
+	if err != nil {
+		return failure(err)
+	}
+	if err := deleteWithSubtasks(id, *cascade); err != nil {
+		return failure(err)
+	}
+
+	if *asJSON {
+		return printJSON(task)
+	}
+	fmt.Printf("Moved task %d to the trash\n", id)
+	return exitOK
+}
+
+// removeSelection moves the tasks selected by expr to the trash
+func removeSelection(expr string, cascade, yes, asJSON bool) int {
+	tasks, err := selectTasks(expr)
+	if err != nil {
+		return failure(err)
+	}
+	if len(tasks) > 0 {
+		if ok, err := confirmSelection("Delete", tasks, yes, asJSON); err != nil || !ok {
+			return notConfirmed(err)
+		}
+	}
+	if err := deleteSelected(tasks, cascade); err != nil {
+		return failure(err)
+	}
+
+	if asJSON {
+		if tasks == nil {
+			tasks = []Task{}
+		}
+		return printJSON(tasks)
+	}
+	if len(tasks) == 0 {
+		fmt.Println("No tasks to delete")
+		return exitOK
+	}
+	fmt.Printf("Moved tasks %s to the trash\n", formatIDs(taskIDs(tasks)))
+	return exitOK
+}
+
+func cmdEdit(args []string) int {
+	fs := newFlagSet("edit")
+	title := fs.String("title", "", "new task title")
+	desc := fs.String("desc", "", "new task description")
+	priority := fs.String("priority", "", "new priority")
+	due := fs.String("due", "", "new due date, empty to clear it")
+	parent := fs.Int("parent", 0, "new parent task ID, 0 for none")
+	repeat := fs.String("repeat", "", "new recurrence rule, empty to stop repeating")
+	yes := fs.Bool("yes", false, "edit a selection of tasks without asking")
+	asJSON := fs.Bool("json", false, "print the edited task as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	if len(positional) == 0 {
+		return usageFailure(fmt.Errorf("edit expects a task ID, a list of IDs or a filter"))
+	}
+	expr := strings.Join(positional, " ")
+	id, single := singleID(expr)
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	delete(set, "json")
+	delete(set, "yes")
+	if len(set) == 0 {
+		return usageFailure(fmt.Errorf("edit needs at least one of --title, --desc, --priority, --due, --parent or --repeat"))
+	}
+	if set["title"] && *title == "" {
+		return usageFailure(fmt.Errorf("title cannot be empty"))
+	}
+	if set["title"] && !single {
+		return usageFailure(fmt.Errorf("--title can only be changed one task at a time"))
+	}
+
+	var newPriority Priority
+	if set["priority"] {
+		if newPriority, err = ParsePriority(*priority); err != nil {
+			return usageFailure(err)
+		}
+	}
+	var newDue time.Time
+	if set["due"] && *due != "" {
+		if newDue, err = parseDueDate(*due); err != nil {
+			return usageFailure(err)
+		}
+	}
+	if set["repeat"] && *repeat != "" {
+		if _, err := ParseRecurrence(*repeat); err != nil {
+			return usageFailure(err)
+		}
+	}
+	edit := func(task *Task) error {
+		if set["title"] {
+			task.Title = *title
+		}
+		if set["desc"] {
+			task.Description = *desc
+		}
+		if set["priority"] {
+			task.Priority = newPriority
+		}
+		if set["due"] {
+			task.DueDate = nil
+			if *due != "" {
+				dueDate := newDue
+				task.DueDate = &dueDate
+			}
+		}
+		if set["repeat"] {
+			task.Recurrence = nil
+			if *repeat != "" {
+				task.Recurrence, _ = ParseRecurrence(*repeat)
+			}
+		}
+		if set["parent"] {
+			all, err := allTasks()
+			if err != nil {
+				return err
+			}
+			if err := validateParent(all, task.ID, *parent); err != nil {
+				return err
+			}
+			task.ParentID = *parent
+		}
+		return nil
+	}
+	if !single {
+		return editSelection(expr, edit, *yes, *asJSON)
+	}
+
+	old, err := getTask(id)
+	if err != nil {
+		return failure(err)
+	}
+	task := snapshot(old)
+	if err := edit(task); err != nil {
+		return failure(err)
+	}
+	changes := diffTasks(*old, *task)
+	if len(changes) > 0 {
+		if err := updateTask(task); err != nil {
+			return failure(err)
+		}
+	}
+
+	if *asJSON {
+		if changes == nil {
+			changes = []fieldChange{}
+		}
+		return printJSON(editedTask{task, changes})
+	}
+	printEdited(editedTask{task, changes})
+	return exitOK
+}
+
+// editSelection applies edit to the tasks selected by expr
+func editSelection(expr string, edit func(task *Task) error, yes, asJSON bool) int {
+	tasks, err := selectTasks(expr)
+	if err != nil {
+		return failure(err)
+	}
+	if len(tasks) > 0 {
+		if ok, err := confirmSelection("Edit", tasks, yes, asJSON); err != nil || !ok {
+			return notConfirmed(err)
+		}
+	}
+	edited, err := editSelected(tasks, edit)
+	if err != nil {
+		return failure(err)
+	}
+
+	if asJSON {
+		if edited == nil {
+			edited = []editedTask{}
+		}
+		return printJSON(edited)
+	}
+	if len(edited) == 0 {
+		fmt.Println("No tasks to edit")
+	}
+	for _, e := range edited {
+		printEdited(e)
+	}
+	return exitOK
+}
+
+// printEdited reports what an edit changed about a task
+func printEdited(e editedTask) {
+	if len(e.Changes) == 0 {
+		fmt.Printf("Task %d unchanged\n", e.Task.ID)
+		return
+	}
+	fmt.Printf("Updated task %d:\n", e.Task.ID)
+	for _, change := range e.Changes {
+		fmt.Println("  " + change.String())
+	}
+}
+
+// cmdTag implements both "tag" and "untag"
+func cmdTag(name string, args []string) int {
+	fs := newFlagSet(name)
+	yes := fs.Bool("yes", false, "tag a selection of tasks without asking")
+	asJSON := fs.Bool("json", false, "print the updated task as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	if len(positional) < 2 {
+		return usageFailure(fmt.Errorf("%s expects a task ID, a list of IDs or a filter, and at least one tag", name))
+	}
+	tags := parseTagList(strings.Join(positional[1:], " "))
+	var add, remove []string
+	if name == "tag" {
+		add = tags
+	} else {
+		remove = tags
+	}
+	id, ok := singleID(positional[0])
+	if !ok {
+		return tagSelection(name, positional[0], add, remove, *yes, *asJSON)
+	}
+
+	task, err := getTask(id)
+	if err != nil {
+		return failure(err)
+	}
+	task.AddTags(add...)
+	task.RemoveTags(remove...)
+	if err := updateTask(task); err != nil {
+		return failure(err)
+	}
+
+	if *asJSON {
+		return printJSON(task)
+	}
+	fmt.Printf("Task %d tags: %s\n", task.ID, strings.Join(task.Tags, ", "))
+	return exitOK
+}
+
+// tagSelection adds and removes tags on the tasks selected by expr
+func tagSelection(name, expr string, add, remove []string, yes, asJSON bool) int {
+	tasks, err := selectTasks(expr)
+	if err != nil {
+		return failure(err)
+	}
+	if len(tasks) > 0 {
+		verb := "Tag"
+		if name == "untag" {
+			verb = "Untag"
+		}
+		if ok, err := confirmSelection(verb, tasks, yes, asJSON); err != nil || !ok {
+			return notConfirmed(err)
+		}
+	}
+	updated, err := tagSelected(tasks, add, remove)
+	if err != nil {
+		return failure(err)
+	}
+
+	if asJSON {
+		if updated == nil {
+			updated = []Task{}
+		}
+		return printJSON(updated)
+	}
+	if len(updated) == 0 {
+		fmt.Println("No tasks to tag")
+	}
+	for _, task := range updated {
+		fmt.Printf("Task %d tags: %s\n", task.ID, strings.Join(task.Tags, ", "))
+	}
+	return exitOK
+}
+
+func cmdBlock(name string, args []string) int {
+	fs := newFlagSet(name)
+	asJSON := fs.Bool("json", false, "print the updated task as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	if len(positional) < 2 {
+		return usageFailure(fmt.Errorf("%s expects a task ID and at least one blocking task ID", name))
+	}
+	id, err := parseID(name, positional[:1])
+	if err != nil {
+		return usageFailure(err)
+	}
+	ids, err := parseIDList(strings.Join(positional[1:], ","))
+	if err != nil {
+		return usageFailure(err)
+	}
+
+	task, err := getTask(id)
+	if err != nil {
+		return failure(err)
+	}
+	blockers := append([]int(nil), task.BlockedBy...)
+	if name == "block" {
+		blockers = append(blockers, ids...)
+	} else {
+		remove := map[int]bool{}
+		for _, blocker := range ids {
+			remove[blocker] = true
+		}
+		blockers = blockers[:0]
+		for _, blocker := range task.BlockedBy {
+			if !remove[blocker] {
+				blockers = append(blockers, blocker)
+			}
+		}
+	}
+	if task, err = setBlockers(id, blockers); err != nil {
+		return failure(err)
+	}
+
+	if *asJSON {
+		return printJSON(task)
+	}
+	fmt.Printf("Task %d is blocked by: %s\n", task.ID, formatIDs(task.BlockedBy))
+	return exitOK
+}
+
+func cmdArchive(args []string) int {
+	fs := newFlagSet("archive")
+	asJSON := fs.Bool("json", false, "print the archived tasks as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+
+	var archived []Task
+	if len(positional) == 0 {
+		archived, err = archiveCompleted()
+	} else {
+		var id int
+		if id, err = parseID("archive", positional); err != nil {
+			return usageFailure(err)
+		}
+		archived, err = archiveTask(id)
+	}
+	if err != nil {
+		return failure(err)
+	}
+
+	if *asJSON {
+		if archived == nil {
+			archived = []Task{}
+		}
+		return printJSON(archived)
+	}
+	fmt.Printf("Archived %d task(s)\n", len(archived))
+	return exitOK
+}
+
+func cmdUnarchive(args []string) int {
+	fs := newFlagSet("unarchive")
+	asJSON := fs.Bool("json", false, "print the unarchived tasks as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	id, err := parseID("unarchive", positional)
+	if err != nil {
+		return usageFailure(err)
+	}
+
+	restored, err := unarchiveTask(id)
+	if err != nil {
+		return failure(err)
+	}
+
+	if *asJSON {
+		return printJSON(restored)
+	}
+	fmt.Printf("Unarchived %d task(s)\n", len(restored))
+	return exitOK
+}
+
+func cmdTrash(args []string) int {
+	fs := newFlagSet("trash")
+	asJSON := fs.Bool("json", false, "print the tasks in the trash as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	if len(positional) > 0 {
+		return usageFailure(fmt.Errorf("trash takes no positional arguments"))
+	}
+
+	trashed, err := trashedTasks()
+	if err != nil {
+		return failure(err)
+	}
+
+	if *asJSON {
+		if trashed == nil {
+			trashed = []Task{}
+		}
+		return printJSON(trashed)
+	}
+	printTrash(os.Stdout, trashed)
+	return exitOK
+}
+
+// printTrash writes one line per task in the trash with when it was
+// deleted
+func printTrash(w io.Writer, trashed []Task) {
+	if len(trashed) == 0 {
+		fmt.Fprintln(w, "The trash is empty.")
+		return
+	}
+	for _, task := range trashed {
+		fmt.Fprintf(w, "%4d  %-40s deleted %s\n", task.ID, truncate(task.Title, 40), formatDueDate(task.DeletedAt))
+	}
+}
+
+func cmdRestore(args []string) int {
+	fs := newFlagSet("restore")
+	asJSON := fs.Bool("json", false, "print the restored tasks as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	id, err := parseID("restore", positional)
+	if err != nil {
+		return usageFailure(err)
+	}
+
+	restored, err := restoreTask(id)
+	if err != nil {
+		return failure(err)
+	}
+
+	if *asJSON {
+		return printJSON(restored)
+	}
+	fmt.Printf("Restored %d task(s)\n", len(restored))
+	return exitOK
+}
+
+func cmdPurge(args []string) int {
+	fs := newFlagSet("purge")
+	olderThan := fs.String("older-than", formatDuration(defaultPurgeAge), "only purge tasks deleted at least this long ago, e.g. 12h or 7d")
+	asJSON := fs.Bool("json", false, "print the purged tasks as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	if len(positional) > 0 {
+		return usageFailure(fmt.Errorf("purge takes no positional arguments"))
+	}
+	age, err := parseDuration(*olderThan)
+	if err != nil {
+		return usageFailure(err)
+	}
+
+	purged, err := purgeTrash(age, time.Now())
+	if err != nil {
+		return failure(err)
+	}
+
+	if *asJSON {
+		if purged == nil {
+			purged = []Task{}
+		}
+		return printJSON(purged)
+	}
+	fmt.Printf("Purged %d task(s) from the trash\n", len(purged))
+	return exitOK
+}
+
+func cmdProject(args []string) int {
+	fs := newFlagSet("project")
+	force := fs.Bool("force", false, "delete a project together with its tasks")
+	asJSON := fs.Bool("json", false, "print the projects as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	action := "list"
+	if len(positional) > 0 {
+		action, positional = positional[0], positional[1:]
+	}
+
+	want := map[string]int{"list": 0, "create": 1, "switch": 1, "delete": 1, "rename": 2}
+	n, ok := want[action]
+	if !ok {
+		return usageFailure(fmt.Errorf("unknown project action %q (use list, create, rename, switch or delete)", action))
+	}
+	if len(positional) != n {
+		return usageFailure(fmt.Errorf("project %s expects %d name(s)", action, n))
+	}
+	if *force && action != "delete" {
+		return usageFailure(fmt.Errorf("--force only applies to project delete"))
+	}
+
+	var message string
+	switch action {
+	case "create":
+		err = createProject(positional[0])
+		message = fmt.Sprintf("Created project %s", positional[0])
+	case "switch":
+		err = switchProject(positional[0])
+		message = fmt.Sprintf("Switched to project %s", positional[0])
+	case "rename":
+		err = renameProject(positional[0], positional[1])
+		message = fmt.Sprintf("Renamed project %s to %s", positional[0], positional[1])
+	case "delete":
+		err = deleteProject(positional[0], *force)
+		message = fmt.Sprintf("Deleted project %s", positional[0])
+	}
+	if err != nil {
+		return failure(err)
+	}
+
+	summaries, err := projectSummaries()
+	if err != nil {
+		return failure(err)
+	}
+	if *asJSON {
+		return printJSON(summaries)
+	}
+	if action != "list" {
+		fmt.Println(message)
+		return exitOK
+	}
+	printProjects(os.Stdout, summaries)
+	return exitOK
+}
+
+// printProjects writes one line per project, marking the current one
+func printProjects(w io.Writer, summaries []projectSummary) {
+	for _, p := range summaries {
+		marker := " "
+		if p.Current {
+			marker = "*"
+		}
+		fmt.Fprintf(w, "%s %-20s %3d open, %3d total\n", marker, p.Name, p.Open, p.Tasks)
+	}
+}
+
+func cmdMove(args []string) int {
+	fs := newFlagSet("move")
+	target := fs.String("to", "", "project to move the tasks to")
+	asJSON := fs.Bool("json", false, "print the moved tasks as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	if *target == "" {
+		return usageFailure(fmt.Errorf("move needs --to"))
+	}
+	if len(positional) == 0 {
+		return usageFailure(fmt.Errorf("move expects at least one task ID"))
+	}
+	ids, err := parseIDList(strings.Join(positional, ","))
+	if err != nil {
+		return usageFailure(err)
+	}
+
+	moved, err := moveTasks(ids, *target)
+	if err != nil {
+		return failure(err)
+	}
+
+	if *asJSON {
+		return printJSON(moved)
+	}
+	for _, m := range moved {
+		fmt.Printf("Moved task %d to project %s as task %d\n", m.OldID, *target, m.Task.ID)
+	}
+	return exitOK
+}
+
+func cmdSearch(args []string) int {
+	fs := newFlagSet("search")
+	asJSON := fs.Bool("json", false, "print results with their scores as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	query := strings.Join(positional, " ")
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return usageFailure(fmt.Errorf("search expects at least one word"))
+	}
+
+	tasks, err := allTasks()
+	if err != nil {
+		return failure(err)
+	}
+	results := Search(tasks, query)
+
+	if *asJSON {
+		if results == nil {
+			results = []SearchResult{}
+		}
+		return printJSON(results)
+	}
+	now := time.Now()
+	for _, result := range results {
+		task := result.Task
+		task.Title = highlightTerms(task.Title, terms)
+		printTaskLine(os.Stdout, treeNode{Task: task}, now)
+	}
+	return exitOK
+}
+
+// cmdHistory implements "history", the timeline of one task
+func cmdHistory(args []string) int {
+	fs := newFlagSet("history")
+	asJSON := fs.Bool("json", false, "print the events as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	id, err := parseID("history", positional)
+	if err != nil {
+		return usageFailure(err)
+	}
+
+	events, err := taskHistory(id)
+	if err != nil {
+		return failure(err)
+	}
+
+	if *asJSON {
+		if events == nil {
+			events = []Event{}
+		}
+		return printJSON(events)
+	}
+	printTimeline(os.Stdout, id, events)
+	return exitOK
+}
+
+func cmdCompact(args []string) int {
+	fs := newFlagSet("compact")
+	asJSON := fs.Bool("json", false, "print the number of folded events as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	if len(positional) > 0 {
+		return usageFailure(fmt.Errorf("compact takes no arguments"))
+	}
+
+	c, ok := store.(Compacter)
+	if !ok {
+		return failure(fmt.Errorf("only the JSON file storage has a journal to compact"))
+	}
+	n, err := c.Compact()
+	if err != nil {
+		return failure(err)
+	}
+
+	if *asJSON {
+		return printJSON(struct {
+			Events int `json:"events"`
+		}{n})
+	}
+	fmt.Printf("Folded %d event(s) into the snapshot of project %s\n", n, currentProject)
+	return exitOK
+}
+
+// cmdUndo implements "undo" and "redo" through the given history method
+func cmdUndo(name string, args []string, apply func() (*command, error), verb string) int {
+	if len(args) > 0 {
+		return usageFailure(fmt.Errorf("%s takes no arguments", name))
+	}
+	cmd, err := apply()
+	if err != nil {
+		return failure(err)
+	}
+	fmt.Printf("%s: %s\n", verb, cmd.describe())
+	return exitOK
+}
+
+func cmdExport(args []string) int {
+	fs := newFlagSet("export")
+	format := fs.String("format", "", "csv, md, ics or todotxt (default from --out, else csv)")
+	out := fs.String("out", "", "file to write instead of standard output")
+	expr := fs.String("filter", "", "only export tasks matching this filter expression")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	if len(positional) > 0 {
+		return usageFailure(fmt.Errorf("export takes no positional arguments"))
+	}
+	if *format == "" {
+		*format = formatFromPath(*out)
+	}
+	if *format == "" {
+		*format = formatCSV
+	}
+	filter, err := ParseFilter(*expr)
+	if err != nil {
+		return usageFailure(err)
+	}
+
+	tasks, err := allTasks()
+	if err != nil {
+		return failure(err)
+	}
+	tasks = filter.Apply(tasks, time.Now())
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return failure(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := exportTasks(w, tasks, *format); err != nil {
+		return failure(err)
+	}
+	if *out != "" {
+		fmt.Fprintf(os.Stderr, "Exported %d task(s) to %s\n", len(tasks), *out)
+	}
+	return exitOK
+}
+
+func cmdImport(args []string) int {
+	fs := newFlagSet("import")
+	format := fs.String("format", "", "csv, md or todotxt (default from the file extension)")
+	asJSON := fs.Bool("json", false, "print the imported tasks and skipped lines as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	if len(positional) != 1 {
+		return usageFailure(fmt.Errorf("import expects exactly one file"))
+	}
+	path := positional[0]
+	if *format == "" {
+		*format = formatFromPath(path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return failure(err)
+	}
+	defer f.Close()
+
+	items, problems, err := parseImport(f, *format)
+	if err != nil {
+		return failure(err)
+	}
+	created, linkProblems, err := importTasks(items)
+	if err != nil {
+		return failure(err)
+	}
+	problems = append(problems, linkProblems...)
+
+	if *asJSON {
+		if problems == nil {
+			problems = []importProblem{}
+		}
+		return printJSON(struct {
+			Imported []Task          `json:"imported"`
+			Skipped  []importProblem `json:"skipped"`
+		}{created, problems})
+	}
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stderr, "Skipped %s\n", problem)
+	}
+	fmt.Printf("Imported %d task(s) from %s\n", len(created), path)
+	return exitOK
+}
+
+// cmdInteractive starts the full-screen interface, falling back to the
+// numbered menu when the terminal does not support it
+func cmdInteractive(args []string) int {
+	fs := newFlagSet("interactive")
+	menu := fs.Bool("menu", false, "use the numbered menu instead of the full-screen list")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	if len(positional) > 0 {
+		return usageFailure(fmt.Errorf("interactive takes no positional arguments"))
+	}
+
+	warn := func(err error) { fmt.Fprintln(os.Stderr, "Warning:", err) }
+	if !*menu {
+		scheduler, err := newScheduler(terminalNotifier{w: os.Stdout}, warn)
+		if err != nil {
+			return usageFailure(err)
+		}
+		err = runTUI(scheduler)
+		if err == nil {
+			return exitOK
+		}
+		if err != errNoTerminal {
+			return failure(err)
+		}
+	}
+
+	scheduler, err := newScheduler(terminalNotifier{w: os.Stdout}, warn)
+	if err != nil {
+		return usageFailure(err)
+	}
+	scheduler.Start()
+	defer scheduler.Stop()
+	runMenu()
+	return exitOK
+}
+
+func cmdRemind(args []string) int {
+	fs := newFlagSet("remind")
+	watch := fs.Bool("watch", false, "keep checking until interrupted")
+	asJSON := fs.Bool("json", false, "print the due reminders as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	if len(positional) > 0 {
+		return usageFailure(fmt.Errorf("remind takes no positional arguments"))
+	}
+	if *watch && *asJSON {
+		return usageFailure(fmt.Errorf("--watch and --json cannot be combined"))
+	}
+
+	scheduler, err := newScheduler(terminalNotifier{w: os.Stdout}, func(err error) {
+		fmt.Fprintln(os.Stderr, "Warning:", err)
+	})
+	if err != nil {
+		return usageFailure(err)
+	}
+
+	if *watch {
+		scheduler.Start()
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		<-interrupt
+		scheduler.Stop()
+		return exitOK
+	}
+
+	if !*asJSON {
+		if err := scheduler.Check(time.Now()); err != nil {
+			return failure(err)
+		}
+		return exitOK
+	}
+	now := time.Now()
+	due, err := scheduler.collect(now)
+	if err != nil {
+		return failure(err)
+	}
+	type reminderJSON struct {
+		Project string `json:"project"`
+		Task    Task   `json:"task"`
+		Message string `json:"message"`
+	}
+	out := []reminderJSON{}
+	for _, r := range due {
+		out = append(out, reminderJSON{r.Project, r.Task, r.Message(now)})
+	}
+	code := printJSON(out)
+	if code == exitOK {
+		for _, r := range due {
+			if err := scheduler.markSent(r); err != nil {
+				return failure(err)
+			}
+		}
+	}
+	return code
+}
+
+func cmdSnooze(args []string) int {
+	fs := newFlagSet("snooze")
+	duration := fs.String("for", formatDuration(defaultSnooze), "how long to put off the reminders, e.g. 30m, 2h or 1d")
+	asJSON := fs.Bool("json", false, "print when the reminders resume as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	id, err := parseID("snooze", positional)
+	if err != nil {
+		return usageFailure(err)
+	}
+	d, err := parseDuration(*duration)
+	if err != nil {
+		return usageFailure(err)
+	}
+
+	until, err := snoozeTask(id, d)
+	if err != nil {
+		return failure(err)
+	}
+
+	if *asJSON {
+		return printJSON(struct {
+			ID    int       `json:"id"`
+			Until time.Time `json:"snoozed_until"`
+		}{id, until})
+	}
+	fmt.Printf("Reminders for task %d snoozed until %s\n", id, until.Format("2006-01-02 15:04"))
+	return exitOK
+}
+
+func cmdStart(args []string) int {
+	fs := newFlagSet("start")
+	asJSON := fs.Bool("json", false, "print the task as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	id, err := parseID("start", positional)
+	if err != nil {
+		return usageFailure(err)
+	}
+
+	task, err := startTimer(id)
+	if err != nil {
+		return failure(err)
+	}
+
+	if *asJSON {
+		return printJSON(task)
+	}
+	fmt.Printf("Started timer for task %d\n", id)
+	return exitOK
+}
+
+func cmdStop(args []string) int {
+	fs := newFlagSet("stop")
+	asJSON := fs.Bool("json", false, "print the task as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	id := 0
+	if len(positional) > 0 {
+		if id, err = parseID("stop", positional); err != nil {
+			return usageFailure(err)
+		}
+	}
+
+	task, err := stopTimer(id)
+	if err != nil {
+		return failure(err)
+	}
+
+	if *asJSON {
+		return printJSON(task)
+	}
+	entry := task.TimeEntries[len(task.TimeEntries)-1]
+	fmt.Printf("Stopped timer for task %d after %s (%s in total)\n", task.ID,
+		formatTracked(entry.End.Sub(entry.Start)), formatTracked(task.Tracked(time.Now())))
+	return exitOK
+}
+
+func cmdTimesheet(args []string) int {
+	now := time.Now()
+	fs := newFlagSet("timesheet")
+	fromText := fs.String("from", thisWeek(now).Format("2006-01-02"), "first day of the report")
+	toText := fs.String("to", now.Format("2006-01-02"), "last day of the report")
+	asJSON := fs.Bool("json", false, "print the timesheet as JSON, in minutes")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	if len(positional) > 0 {
+		return usageFailure(fmt.Errorf("timesheet takes no positional arguments"))
+	}
+	from, err := parseDay(*fromText)
+	if err != nil {
+		return usageFailure(err)
+	}
+	to, err := parseDay(*toText)
+	if err != nil {
+		return usageFailure(err)
+	}
+	if to.Before(from) {
+		return usageFailure(fmt.Errorf("--to is before --from"))
+	}
+
+	tasks, err := trackedTasks()
+	if err != nil {
+		return failure(err)
+	}
+	sheet := buildTimesheet(tasks, from, to, now)
+
+	if *asJSON {
+		return printJSON(sheet)
+	}
+	printTimesheet(os.Stdout, sheet)
+	return exitOK
+}
+
+func cmdReport(args []string) int {
+	now := time.Now()
+	fs := newFlagSet("report")
+	fromText := fs.String("from", startOfDay(now).AddDate(0, 0, 1-reportDays).Format("2006-01-02"), "first day of the report")
+	toText := fs.String("to", now.Format("2006-01-02"), "last day of the report")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagFailure(err)
+	}
+	if len(positional) > 0 {
+		return usageFailure(fmt.Errorf("report takes no positional arguments"))
+	}
+	from, err := parseDay(*fromText)
+	if err != nil {
+		return usageFailure(err)
+	}
+	to, err := parseDay(*toText)
+	if err != nil {
+		return usageFailure(err)
+	}
+	if to.Before(from) {
+		return usageFailure(fmt.Errorf("--to is before --from"))
+	}
+
+	tasks, err := store.List(ListOptions{Archived: true, Trashed: true})
+	if err != nil {
+		return failure(err)
+	}
+	report := buildReport(tasks, from, to, now)
+
+	if *asJSON {
+		return printJSON(report)
+	}
+	printReport(os.Stdout, report)
+	return exitOK
+}
@@ -0,0 +1,31 @@
+package main
+
+import "os"
+
+// ANSI escape sequences used to highlight output
+const (
+	ansiReset  = "\033[0m"
+	ansiBold   = "\033[1m"
+	ansiRed    = "\033[31m"
+	ansiYellow = "\033[33m"
+)
+
+// colorEnabled is true when stdout is a terminal and NO_COLOR is unset
+var colorEnabled = isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == ""
+
+// isTerminal reports whether f is connected to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize wraps s in the given escape sequence when color is enabled
+func colorize(code, s string) string {
+	if !colorEnabled {
+		return s
+	}
+	return code + s + ansiReset
+}
@@ -0,0 +1,602 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Database handles task storage in SQLite. Each Database works on the
+// tasks of one project; Project returns one for another project that
+// shares the connection.
+type Database struct {
+	db      *sql.DB
+	project string
+	shared  bool
+	// tx is the transaction of Atomic, which the task methods use
+	// instead of db
+	tx *sql.Tx
+}
+
+// NewDatabase creates a new database connection.
+// SQLite serialises writers itself; the busy timeout makes a second
+// process wait for the lock instead of failing straight away. Every
+// transaction takes the write lock when it begins: one that only took it
+// on its first write, after reading, could not wait for it and would
+// fail with "database is locked" when another process wrote meanwhile.
+func NewDatabase(dbPath string) (*Database, error) {
+	db, err := sql.Open("sqlite3", dbPath+"?_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate")
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %v", err)
+	}
+
+	return &Database{db: db, project: defaultProject}, nil
+}
+
+// Project returns a Database for the tasks of project name. Closing it
+// leaves the shared connection open.
+func (d *Database) Project(name string) *Database {
+	return &Database{db: d.db, project: name, shared: true}
+}
+
+// Close closes the database connection
+func (d *Database) Close() error {
+	if d.shared {
+		return nil
+	}
+	return d.db.Close()
+}
+
+// dbConn is implemented by *sql.DB and *sql.Tx
+type dbConn interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// conn returns the transaction the task methods run in, if any, or the
+// database
+func (d *Database) conn() dbConn {
+	if d.tx != nil {
+		return d.tx
+	}
+	return d.db
+}
+
+// write runs fn in the transaction of Atomic or, outside it, in a
+// transaction of its own
+func (d *Database) write(fn func(tx *sql.Tx) error) error {
+	if d.tx != nil {
+		return fn(d.tx)
+	}
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// Atomic runs fn with a Database for the same project whose task
+// changes are made in one transaction, committed when fn succeeds
+func (d *Database) Atomic(fn func(TaskStore) error) error {
+	if d.tx != nil {
+		return fn(d)
+	}
+	return d.write(func(tx *sql.Tx) error {
+		return fn(&Database{db: d.db, project: d.project, shared: true, tx: tx})
+	})
+}
+
+// migrations upgrade the tasks table created by InitSchema. Entry i
+// moves a database from schema version i to i+1; SQLite's user_version
+// records how many have been applied.
+var migrations = []string{
+	`ALTER TABLE tasks ADD COLUMN priority INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE tasks ADD COLUMN due_date DATETIME`,
+	`ALTER TABLE tasks ADD COLUMN tags TEXT NOT NULL DEFAULT '[]'`,
+	`ALTER TABLE tasks ADD COLUMN parent_id INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE tasks ADD COLUMN recurrence TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE tasks ADD COLUMN time_entries TEXT NOT NULL DEFAULT '[]'`,
+	`ALTER TABLE tasks ADD COLUMN blocked_by TEXT NOT NULL DEFAULT '[]'`,
+	// Every project numbers its tasks from 1, so the table is rebuilt
+	// with a key of project and ID, and the projects table keeps each
+	// project's next ID, as AUTOINCREMENT did for the single list
+	`CREATE TABLE projects (
+		name TEXT PRIMARY KEY,
+		next_id INTEGER NOT NULL DEFAULT 1
+	);
+	INSERT INTO projects (name, next_id)
+		SELECT 'default', MAX(
+			COALESCE((SELECT seq FROM sqlite_sequence WHERE name = 'tasks'), 0),
+			COALESCE((SELECT MAX(id) FROM tasks), 0)) + 1;
+	CREATE TABLE tasks_by_project (
+		project TEXT NOT NULL DEFAULT 'default',
+		id INTEGER NOT NULL,
+		title TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		completed BOOLEAN NOT NULL DEFAULT 0,
+		priority INTEGER NOT NULL DEFAULT 0,
+		due_date DATETIME,
+		tags TEXT NOT NULL DEFAULT '[]',
+		parent_id INTEGER NOT NULL DEFAULT 0,
+		recurrence TEXT NOT NULL DEFAULT '',
+		time_entries TEXT NOT NULL DEFAULT '[]',
+		blocked_by TEXT NOT NULL DEFAULT '[]',
+		PRIMARY KEY (project, id)
+	);
+	INSERT INTO tasks_by_project (project, id, title, description, completed, priority, due_date, tags, parent_id, recurrence, time_entries, blocked_by)
+		SELECT 'default', id, title, description, completed, priority, due_date, tags, parent_id, recurrence, time_entries, blocked_by FROM tasks;
+	DROP TABLE tasks;
+	ALTER TABLE tasks_by_project RENAME TO tasks`,
+	`ALTER TABLE tasks ADD COLUMN archived_at DATETIME`,
+	`ALTER TABLE tasks ADD COLUMN deleted_at DATETIME`,
+	`ALTER TABLE tasks ADD COLUMN created_at DATETIME`,
+	`ALTER TABLE tasks ADD COLUMN completed_at DATETIME`,
+	// Every change to a task is also recorded as an event, with the
+	// task as it was afterwards encoded as JSON
+	`CREATE TABLE task_events (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		project TEXT NOT NULL,
+		task_id INTEGER NOT NULL,
+		time DATETIME NOT NULL,
+		user TEXT NOT NULL DEFAULT '',
+		type TEXT NOT NULL,
+		task TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX task_events_by_task ON task_events (project, task_id)`,
+	`ALTER TABLE tasks ADD COLUMN extra TEXT NOT NULL DEFAULT '[]'`,
+	`ALTER TABLE tasks ADD COLUMN state TEXT NOT NULL DEFAULT ''`,
+}
+
+// taskColumns lists the columns scanTask expects, in order
+const taskColumns = `id, title, description, completed, priority, due_date, tags, parent_id, recurrence, time_entries, blocked_by, archived_at, deleted_at, created_at, completed_at, extra, state`
+
+// InitSchema creates the necessary tables and applies pending migrations
+func (d *Database) InitSchema() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS tasks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			title TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			completed BOOLEAN NOT NULL DEFAULT 0
+		)
+	`
+
+	if _, err := d.db.Exec(query); err != nil {
+		return err
+	}
+	return d.migrate()
+}
+
+// migrate applies the migrations newer than the database's version in
+// one transaction
+func (d *Database) migrate() error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("error reading schema version: %v", err)
+	}
+	if version >= len(migrations) {
+		return nil
+	}
+
+	for i, migration := range migrations[version:] {
+		if _, err := tx.Exec(migration); err != nil {
+			return fmt.Errorf("error applying migration %d: %v", version+i+1, err)
+		}
+	}
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, len(migrations))); err != nil {
+		return fmt.Errorf("error updating schema version: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanTask reads a row selected with taskColumns
+func scanTask(row rowScanner) (Task, error) {
+	var task Task
+	var due, archived, deleted, created, completed sql.NullTime
+	var tags, recurrence, entries, blockedBy, extra string
+	err := row.Scan(&task.ID, &task.Title, &task.Description, &task.Completed,
+		&task.Priority, &due, &tags, &task.ParentID, &recurrence, &entries, &blockedBy, &archived, &deleted, &created, &completed, &extra, &task.State)
+	if err != nil {
+		return Task{}, err
+	}
+	task.DueDate = localTime(due)
+	task.ArchivedAt = localTime(archived)
+	task.DeletedAt = localTime(deleted)
+	task.CreatedAt = localTime(created)
+	task.CompletedAt = localTime(completed)
+	if err := fromJSONColumn(tags, &task.Tags); err != nil {
+		return Task{}, fmt.Errorf("task %d has invalid tags: %v", task.ID, err)
+	}
+	if err := fromJSONColumn(entries, &task.TimeEntries); err != nil {
+		return Task{}, fmt.Errorf("task %d has invalid time entries: %v", task.ID, err)
+	}
+	if err := fromJSONColumn(blockedBy, &task.BlockedBy); err != nil {
+		return Task{}, fmt.Errorf("task %d has invalid blockers: %v", task.ID, err)
+	}
+	if err := fromJSONColumn(extra, &task.Extra); err != nil {
+		return Task{}, fmt.Errorf("task %d has invalid extra tokens: %v", task.ID, err)
+	}
+	if recurrence != "" {
+		if task.Recurrence, err = ParseRecurrence(recurrence); err != nil {
+			return Task{}, fmt.Errorf("task %d: %v", task.ID, err)
+		}
+	}
+	return task, nil
+}
+
+// localTime converts a nullable DATETIME column to local time
+func localTime(value sql.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	t := value.Time.Local()
+	return &t
+}
+
+// recurrenceColumn stores a task's recurrence rule in its written form
+func recurrenceColumn(task *Task) string {
+	if task.Recurrence == nil {
+		return ""
+	}
+	return task.Recurrence.String()
+}
+
+// toJSONColumn encodes list-like fields for storage in a TEXT column
+func toJSONColumn(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// fromJSONColumn decodes a TEXT column written by toJSONColumn
+func fromJSONColumn(s string, v interface{}) error {
+	if s == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(s), v)
+}
+
+// Create adds a new task to the project and sets its ID. A task that
+// already has an ID keeps it.
+func (d *Database) Create(task *Task) error {
+	query := `
+		INSERT INTO tasks (project, id, title, description, completed, priority, due_date, tags, parent_id, recurrence, time_entries, blocked_by, archived_at, deleted_at, created_at, completed_at, extra, state)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	tags, err := toJSONColumn(task.Tags)
+	if err != nil {
+		return fmt.Errorf("error encoding tags: %v", err)
+	}
+	entries, err := toJSONColumn(task.TimeEntries)
+	if err != nil {
+		return fmt.Errorf("error encoding time entries: %v", err)
+	}
+	blockedBy, err := toJSONColumn(task.BlockedBy)
+	if err != nil {
+		return fmt.Errorf("error encoding blockers: %v", err)
+	}
+	extra, err := toJSONColumn(task.Extra)
+	if err != nil {
+		return fmt.Errorf("error encoding extra tokens: %v", err)
+	}
+
+	var id int
+	err = d.write(func(tx *sql.Tx) error {
+		var nextID int
+		err := tx.QueryRow(`SELECT next_id FROM projects WHERE name = ?`, d.project).Scan(&nextID)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("project %q does not exist", d.project)
+		}
+		if err != nil {
+			return fmt.Errorf("error reading next task ID: %v", err)
+		}
+		id = task.ID
+		if id == 0 {
+			id = nextID
+		}
+
+		_, err = tx.Exec(query, d.project, id, task.Title, task.Description, task.Completed,
+			task.Priority, task.DueDate, tags, task.ParentID, recurrenceColumn(task), entries, blockedBy,
+			task.ArchivedAt, task.DeletedAt, task.CreatedAt, task.CompletedAt, extra, task.State)
+		if err != nil {
+			return fmt.Errorf("error creating task: %v", err)
+		}
+		if id >= nextID {
+			if _, err := tx.Exec(`UPDATE projects SET next_id = ? WHERE name = ?`, id+1, d.project); err != nil {
+				return fmt.Errorf("error updating next task ID: %v", err)
+			}
+		}
+		created := *task
+		created.ID = id
+		return d.recordEvent(tx, nil, &created)
+	})
+	if err != nil {
+		return err
+	}
+	task.ID = id
+	return nil
+}
+
+// Get retrieves a task of the project by ID
+func (d *Database) Get(id int) (*Task, error) {
+	return d.get(d.conn(), id)
+}
+
+// get retrieves a task of the project by ID through conn
+func (d *Database) get(conn dbConn, id int) (*Task, error) {
+	query := `SELECT ` + taskColumns + ` FROM tasks WHERE project = ? AND id = ?`
+
+	task, err := scanTask(conn.QueryRow(query, d.project, id))
+	if err == sql.ErrNoRows {
+		return nil, errTaskNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting task: %v", err)
+	}
+
+	return &task, nil
+}
+
+// Update updates an existing task of the project
+func (d *Database) Update(task *Task) error {
+	query := `
+		UPDATE tasks
+		SET title = ?, description = ?, completed = ?, priority = ?, due_date = ?, tags = ?,
+			parent_id = ?, recurrence = ?, time_entries = ?, blocked_by = ?, archived_at = ?, deleted_at = ?,
+			created_at = ?, completed_at = ?, extra = ?, state = ?
+		WHERE project = ? AND id = ?
+	`
+
+	tags, err := toJSONColumn(task.Tags)
+	if err != nil {
+		return fmt.Errorf("error encoding tags: %v", err)
+	}
+	entries, err := toJSONColumn(task.TimeEntries)
+	if err != nil {
+		return fmt.Errorf("error encoding time entries: %v", err)
+	}
+	blockedBy, err := toJSONColumn(task.BlockedBy)
+	if err != nil {
+		return fmt.Errorf("error encoding blockers: %v", err)
+	}
+	extra, err := toJSONColumn(task.Extra)
+	if err != nil {
+		return fmt.Errorf("error encoding extra tokens: %v", err)
+	}
+
+	return d.write(func(tx *sql.Tx) error {
+		before, err := d.get(tx, task.ID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(query, task.Title, task.Description, task.Completed,
+			task.Priority, task.DueDate, tags, task.ParentID, recurrenceColumn(task), entries, blockedBy,
+			task.ArchivedAt, task.DeletedAt, task.CreatedAt, task.CompletedAt, extra, task.State, d.project, task.ID)
+		if err != nil {
+			return fmt.Errorf("error updating task: %v", err)
+		}
+		return d.recordEvent(tx, before, task)
+	})
+}
+
+// Delete removes a task from the project
+func (d *Database) Delete(id int) error {
+	query := `DELETE FROM tasks WHERE project = ? AND id = ?`
+
+	return d.write(func(tx *sql.Tx) error {
+		before, err := d.get(tx, id)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(query, d.project, id); err != nil {
+			return fmt.Errorf("error deleting task: %v", err)
+		}
+		return d.recordEvent(tx, before, nil)
+	})
+}
+
+// recordEvent adds the change of a task from before to after to the
+// task's events
+func (d *Database) recordEvent(tx *sql.Tx, before, after *Task) error {
+	event := newEvent(before, after)
+	state := ""
+	if event.Task != nil {
+		data, err := json.Marshal(event.Task)
+		if err != nil {
+			return fmt.Errorf("error encoding event: %v", err)
+		}
+		state = string(data)
+	}
+	_, err := tx.Exec(`INSERT INTO task_events (project, task_id, time, user, type, task) VALUES (?, ?, ?, ?, ?, ?)`,
+		d.project, event.ID, event.Time, event.User, event.Type, state)
+	if err != nil {
+		return fmt.Errorf("error recording event: %v", err)
+	}
+	return nil
+}
+
+// Events returns the changes to task id of the project, oldest first
+func (d *Database) Events(id int) ([]Event, error) {
+	query := `SELECT seq, time, user, type, task FROM task_events WHERE project = ? AND task_id = ? ORDER BY seq`
+
+	rows, err := d.conn().Query(query, d.project, id)
+	if err != nil {
+		return nil, fmt.Errorf("error querying events: %v", err)
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		event := Event{ID: id}
+		var state string
+		if err := rows.Scan(&event.Seq, &event.Time, &event.User, &event.Type, &state); err != nil {
+			return nil, fmt.Errorf("error scanning event: %v", err)
+		}
+		if state != "" {
+			event.Task = &Task{}
+			if err := json.Unmarshal([]byte(state), event.Task); err != nil {
+				return nil, fmt.Errorf("event %d has an invalid task: %v", event.Seq, err)
+			}
+		}
+		event.Time = event.Time.Local()
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying events: %v", err)
+	}
+	return events, nil
+}
+
+// List retrieves the tasks of the project selected by opts
+func (d *Database) List(opts ListOptions) ([]Task, error) {
+	query := `SELECT ` + taskColumns + ` FROM tasks WHERE project = ? ORDER BY id`
+
+	rows, err := d.conn().Query(query, d.project)
+	if err != nil {
+		return nil, fmt.Errorf("error querying tasks: %v", err)
+	}
+	defer rows.Close()
+
+	var tasks []Task
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning task: %v", err)
+		}
+		tasks = append(tasks, task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying tasks: %v", err)
+	}
+
+	return opts.apply(tasks)
+}
+
+// ListProjects returns the names of all projects in alphabetical order
+func (d *Database) ListProjects() ([]string, error) {
+	rows, err := d.db.Query(`SELECT name FROM projects ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying projects: %v", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("error scanning project: %v", err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying projects: %v", err)
+	}
+	return names, nil
+}
+
+// OpenProject returns the store for the tasks of an existing project
+func (d *Database) OpenProject(name string) (TaskStore, error) {
+	var exists bool
+	err := d.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM projects WHERE name = ?)`, name).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up project: %v", err)
+	}
+	if !exists {
+		return nil, errProjectNotFound
+	}
+	return d.Project(name), nil
+}
+
+// CreateProject adds an empty project
+func (d *Database) CreateProject(name string) error {
+	if _, err := d.db.Exec(`INSERT INTO projects (name) VALUES (?)`, name); err != nil {
+		return fmt.Errorf("error creating project %q: %v", name, err)
+	}
+	return nil
+}
+
+// RenameProject renames a project together with its tasks
+func (d *Database) RenameProject(oldName, newName string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE projects SET name = ? WHERE name = ?`, newName, oldName)
+	if err != nil {
+		return fmt.Errorf("error renaming project: %v", err)
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return errProjectNotFound
+	}
+	if _, err := tx.Exec(`UPDATE tasks SET project = ? WHERE project = ?`, newName, oldName); err != nil {
+		return fmt.Errorf("error moving tasks: %v", err)
+	}
+	if _, err := tx.Exec(`UPDATE task_events SET project = ? WHERE project = ?`, newName, oldName); err != nil {
+		return fmt.Errorf("error moving task events: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// DeleteProject removes a project and all of its tasks
+func (d *Database) DeleteProject(name string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM projects WHERE name = ?`, name)
+	if err != nil {
+		return fmt.Errorf("error deleting project: %v", err)
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return errProjectNotFound
+	}
+	if _, err := tx.Exec(`DELETE FROM tasks WHERE project = ?`, name); err != nil {
+		return fmt.Errorf("error deleting tasks: %v", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM task_events WHERE project = ?`, name); err != nil {
+		return fmt.Errorf("error deleting task events: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// endOfDay is the time of day of a due date given without one
+const endOfDay = 23*time.Hour + 59*time.Minute
+
+// absoluteDateLayouts are the written date layouts parseDate accepts.
+// Month names match in any case.
+var absoluteDateLayouts = []string{
+	"2006-01-02",
+	"2006/01/02",
+	"02.01.2006",
+	"Jan 2 2006",
+	"Jan 2, 2006",
+	"January 2 2006",
+	"January 2, 2006",
+	"2 Jan 2006",
+	"2 January 2006",
+}
+
+// yearlessDateLayouts name a day without a year, which is the next such
+// day from today on
+var yearlessDateLayouts = []string{"Jan 2", "January 2", "2 Jan", "2 January"}
+
+var (
+	clockPattern = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(am|pm)?$`)
+	// "in 2 hours" is an exact time; "in 3 days" is a day, which may be
+	// followed by a time of day
+	exactPattern    = regexp.MustCompile(`^in (\d+|a|an) (minute|hour)s?$`)
+	relativePattern = regexp.MustCompile(`^in (\d+|a|an) (day|week|month|year)s?$`)
+)
+
+// parseDate interprets a date written in words or digits relative to
+// now, in now's time zone. It understands phrases such as "tomorrow 5pm",
+// "next friday", "in 3 days", "end of month" and "jun 14", as well as
+// dates like 2024-06-14 or "2024-06-14 17:00". A day without a time of
+// day means the end of that day; "in 2 hours" is an exact time.
+func parseDate(s string, now time.Time) (time.Time, error) {
+	text := strings.Join(strings.Fields(strings.ToLower(strings.ReplaceAll(s, ",", ", "))), " ")
+	if text == "" {
+		return time.Time{}, fmt.Errorf("empty date")
+	}
+	if m := exactPattern.FindStringSubmatch(text); m != nil {
+		return addRelative(now, m[1], m[2]), nil
+	}
+
+	words := strings.Fields(text)
+	clock, hasClock := time.Duration(0), false
+	n := len(words)
+	if d, ok := parseClock(words[n-1]); ok {
+		clock, hasClock = d, true
+		words = words[:n-1]
+	} else if n > 1 && (words[n-1] == "am" || words[n-1] == "pm") {
+		if d, ok := parseClock(words[n-2] + words[n-1]); ok {
+			clock, hasClock = d, true
+			words = words[:n-2]
+		}
+	}
+	if n := len(words); hasClock && n > 0 && words[n-1] == "at" {
+		words = words[:n-1]
+	}
+
+	today := startOfDay(now)
+	if len(words) == 0 {
+		if !hasClock {
+			return time.Time{}, invalidDate(s)
+		}
+		// A bare time of day that has passed means tomorrow
+		due := atClock(today, clock)
+		if !due.After(now) {
+			due = atClock(today.AddDate(0, 0, 1), clock)
+		}
+		return due, nil
+	}
+
+	day, ok := parseDayPhrase(strings.Join(words, " "), today)
+	if !ok {
+		return time.Time{}, invalidDate(s)
+	}
+	if !hasClock {
+		clock = endOfDay
+	}
+	return atClock(day, clock), nil
+}
+
+// atClock returns the time clock after midnight on day's date as the
+// wall clock shows it, so that days on which the clocks change keep the
+// right hour
+func atClock(day time.Time, clock time.Duration) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), int(clock/time.Hour), int(clock%time.Hour/time.Minute), 0, 0, time.Local)
+}
+
+// parseDayPhrase interprets the date part of a phrase as a local
+// midnight
+func parseDayPhrase(text string, today time.Time) (time.Time, bool) {
+	switch text {
+	case "today", "tonight":
+		return today, true
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), true
+	case "next week":
+		return thisWeek(today).AddDate(0, 0, 7), true
+	case "end of week":
+		return thisWeek(today).AddDate(0, 0, 6), true
+	case "next month":
+		return time.Date(today.Year(), today.Month()+1, 1, 0, 0, 0, 0, time.Local), true
+	case "end of month":
+		return time.Date(today.Year(), today.Month()+1, 0, 0, 0, 0, 0, time.Local), true
+	case "end of year":
+		return time.Date(today.Year(), 12, 31, 0, 0, 0, 0, time.Local), true
+	}
+	if m := relativePattern.FindStringSubmatch(text); m != nil {
+		return addRelative(today, m[1], m[2]), true
+	}
+
+	// "friday" and "next friday" are the coming one, "this friday" may
+	// be today
+	words := strings.Fields(text)
+	if n := len(words); n <= 2 {
+		if weekday, err := parseWeekday(words[n-1]); err == nil && (n == 1 || words[0] == "next" || words[0] == "this") {
+			days := (int(weekday) - int(today.Weekday()) + 7) % 7
+			if days == 0 && words[0] != "this" {
+				days = 7
+			}
+			return today.AddDate(0, 0, days), true
+		}
+	}
+
+	for _, layout := range absoluteDateLayouts {
+		if t, err := time.ParseInLocation(layout, text, time.Local); err == nil {
+			return t, true
+		}
+	}
+	for _, layout := range yearlessDateLayouts {
+		if t, err := time.ParseInLocation(layout, text, time.Local); err == nil {
+			day := time.Date(today.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+			if day.Before(today) {
+				day = day.AddDate(1, 0, 0)
+			}
+			return day, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseClock parses a time of day such as 17:30, 5pm, 5:30pm or noon and
+// returns it as the time since midnight. A plain number is not a time.
+func parseClock(word string) (time.Duration, bool) {
+	switch word {
+	case "noon":
+		return 12 * time.Hour, true
+	case "midnight":
+		return 0, true
+	}
+	m := clockPattern.FindStringSubmatch(word)
+	if m == nil || (m[2] == "" && m[3] == "") {
+		return 0, false
+	}
+	hour, _ := strconv.Atoi(m[1])
+	minute := 0
+	if m[2] != "" {
+		minute, _ = strconv.Atoi(m[2])
+	}
+	switch m[3] {
+	case "am", "pm":
+		if hour < 1 || hour > 12 {
+			return 0, false
+		}
+		hour %= 12
+		if m[3] == "pm" {
+			hour += 12
+		}
+	}
+	if hour > 23 || minute > 59 {
+		return 0, false
+	}
+	return time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute, true
+}
+
+// addRelative adds "in N units" to now, counting days and longer in
+// calendar days, so they are not thrown off by daylight saving time
+func addRelative(now time.Time, count, unit string) time.Time {
+	n := 1
+	if count != "a" && count != "an" {
+		n, _ = strconv.Atoi(count)
+	}
+	switch unit {
+	case "minute":
+		return now.Add(time.Duration(n) * time.Minute)
+	case "hour":
+		return now.Add(time.Duration(n) * time.Hour)
+	case "day":
+		return now.AddDate(0, 0, n)
+	case "week":
+		return now.AddDate(0, 0, 7*n)
+	case "month":
+		return now.AddDate(0, n, 0)
+	}
+	return now.AddDate(n, 0, 0)
+}
+
+// invalidDate reports a date parseDate does not understand
+func invalidDate(s string) error {
+	return fmt.Errorf("invalid due date %q (try \"tomorrow 5pm\", \"next friday\", \"in 3 days\" or YYYY-MM-DD HH:MM)", s)
+}
+
+// describeDate renders a parsed date with its weekday so the user can
+// check it, e.g. "Fri 2024-06-14 17:00"
+func describeDate(t time.Time) string {
+	return t.Format("Mon 2006-01-02 15:04")
+}
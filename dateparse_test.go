@@ -0,0 +1,102 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestParseDate tests phrases and layouts against a fixed now
+func TestParseDate(t *testing.T) {
+	// Wednesday 12 June 2024, 10:00
+	now := time.Date(2024, 6, 12, 10, 0, 0, 0, time.Local)
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, time.Local)
+	}
+
+	tests := []struct {
+		input string
+		want  time.Time
+	}{
+		{"today", at(6, 12, 23, 59)},
+		{"Tomorrow 5pm", at(6, 13, 17, 0)},
+		{"tomorrow at 9:30 am", at(6, 13, 9, 30)},
+		{"5pm", at(6, 12, 17, 0)},
+		{"9am", at(6, 13, 9, 0)},
+		{"noon", at(6, 12, 12, 0)},
+		{"friday", at(6, 14, 23, 59)},
+		{"next friday", at(6, 14, 23, 59)},
+		{"wednesday", at(6, 19, 23, 59)},
+		{"this wed", at(6, 12, 23, 59)},
+		{"in 3 days", at(6, 15, 23, 59)},
+		{"in 2 weeks 08:00", at(6, 26, 8, 0)},
+		{"in a month", at(7, 12, 23, 59)},
+		{"in 2 hours", at(6, 12, 12, 0)},
+		{"next week", at(6, 17, 23, 59)},
+		{"end of week", at(6, 16, 23, 59)},
+		{"end of month", at(6, 30, 23, 59)},
+		{"next month", at(7, 1, 23, 59)},
+		{"2024-07-04", at(7, 4, 23, 59)},
+		{"2024-07-04 15:04", at(7, 4, 15, 4)},
+		{"2024/07/04", at(7, 4, 23, 59)},
+		{"04.07.2024", at(7, 4, 23, 59)},
+		{"July 4, 2024", at(7, 4, 23, 59)},
+		{"4 jul 2024 6pm", at(7, 4, 18, 0)},
+		{"jul 4", at(7, 4, 23, 59)},
+		{"jan 5", time.Date(2025, 1, 5, 23, 59, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		got, err := parseDate(tt.input, now)
+		if err != nil {
+			t.Errorf("parseDate(%q) returned error: %v", tt.input, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseDate(%q) = %s; want %s", tt.input, describeDate(got), describeDate(tt.want))
+		}
+	}
+
+	for _, input := range []string{"", "someday", "13pm", "25:00", "in three days", "next"} {
+		if got, err := parseDate(input, now); err == nil {
+			t.Errorf("parseDate(%q) = %s; want an error", input, describeDate(got))
+		}
+	}
+}
+
+// TestParseDateDST tests that days on which the clocks change keep the
+// time of day asked for
+func TestParseDateDST(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	saved := time.Local
+	time.Local = newYork
+	defer func() { time.Local = saved }()
+
+	// The clocks go forward at 2am on Sunday 10 March 2024
+	now := time.Date(2024, 3, 9, 10, 0, 0, 0, newYork)
+	tests := []struct {
+		input string
+		want  time.Time
+	}{
+		{"tomorrow 5pm", time.Date(2024, 3, 10, 17, 0, 0, 0, newYork)},
+		{"tomorrow", time.Date(2024, 3, 10, 23, 59, 0, 0, newYork)},
+		{"2024-03-10", time.Date(2024, 3, 10, 23, 59, 0, 0, newYork)},
+		{"2024-11-03 9am", time.Date(2024, 11, 3, 9, 0, 0, 0, newYork)},
+	}
+	for _, tt := range tests {
+		got, err := parseDate(tt.input, now)
+		if err != nil {
+			t.Errorf("parseDate(%q) returned error: %v", tt.input, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseDate(%q) = %s; want %s", tt.input, describeDate(got), describeDate(tt.want))
+		}
+		if tt.want.Hour() == 23 {
+			if due := todoDue(got); due != "2024-03-10" {
+				t.Errorf("todoDue(%s) = %s; want 2024-03-10", describeDate(got), due)
+			}
+		}
+	}
+}
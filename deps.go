@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// taskIndex maps task IDs to tasks
+func taskIndex(tasks []Task) map[int]Task {
+	index := make(map[int]Task, len(tasks))
+	for _, task := range tasks {
+		index[task.ID] = task
+	}
+	return index
+}
+
+// openBlockers returns the IDs of the tasks blocking task that are not
+// completed yet. Links to deleted tasks are ignored.
+func openBlockers(index map[int]Task, task Task) []int {
+	var open []int
+	for _, id := range task.BlockedBy {
+		if blocker, ok := index[id]; ok && !blocker.Completed {
+			open = append(open, id)
+		}
+	}
+	return open
+}
+
+// markBlocked records on each open node which of its blockers are still
+// open, looking them up in all, the complete task list
+func markBlocked(nodes []treeNode, all []Task) {
+	index := taskIndex(all)
+	for i := range nodes {
+		if !nodes[i].Task.Completed {
+			nodes[i].Blockers = openBlockers(index, nodes[i].Task)
+		}
+	}
+}
+
+// layoutTasks arranges listed tasks of the current project for display
+func layoutTasks(tasks []Task, order string) ([]treeNode, error) {
+	all, err := allTasks()
+	if err != nil {
+		return nil, err
+	}
+	return arrangeTasks(tasks, all, order), nil
+}
+
+// arrangeTasks arranges listed tasks for display: as a subtask tree, or
+// for the dependency order as a flat list, since nesting subtasks would
+// break that order. Open blockers are marked on every node, looking
+// them up in all, the complete task list of the project.
+func arrangeTasks(tasks, all []Task, order string) []treeNode {
+	var nodes []treeNode
+	if order == sortDeps {
+		for _, task := range tasks {
+			nodes = append(nodes, treeNode{Task: task})
+		}
+	} else {
+		nodes = buildTree(tasks)
+	}
+	markBlocked(nodes, all)
+	return nodes
+}
+
+// validateBlockers checks that task id may be blocked by blockers: each
+// must exist, and none may be the task itself or wait on it, directly or
+// through other tasks, as that would create a cycle
+func validateBlockers(tasks []Task, id int, blockers []int) error {
+	index := taskIndex(tasks)
+	for _, blocker := range blockers {
+		if blocker == id {
+			return fmt.Errorf("a task cannot block itself")
+		}
+		if _, ok := index[blocker]; !ok {
+			return fmt.Errorf("blocking task %d not found", blocker)
+		}
+		if path := blockPath(index, blocker, id); path != nil {
+			return fmt.Errorf("task %d cannot be blocked by task %d: that would create the cycle %s",
+				id, blocker, formatCycle(append([]int{id}, path...)))
+		}
+	}
+	return nil
+}
+
+// blockPath returns the chain of blocked-by links leading from task from
+// to task to, or nil when to does not block from
+func blockPath(index map[int]Task, from, to int) []int {
+	visited := map[int]bool{}
+	var walk func(id int) []int
+	walk = func(id int) []int {
+		if id == to {
+			return []int{id}
+		}
+		if visited[id] {
+			return nil
+		}
+		visited[id] = true
+		for _, next := range index[id].BlockedBy {
+			if path := walk(next); path != nil {
+				return append([]int{id}, path...)
+			}
+		}
+		return nil
+	}
+	return walk(from)
+}
+
+// formatCycle renders a chain of task IDs as "3 -> 5 -> 3"
+func formatCycle(ids []int) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprint(id)
+	}
+	return strings.Join(parts, " -> ")
+}
+
+// formatIDs renders task IDs as "1, 2, 3", or "-" for none
+func formatIDs(ids []int) string {
+	if len(ids) == 0 {
+		return "-"
+	}
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprint(id)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// maxIDRange is the most IDs a range such as 8-12 may cover, so that a
+// typo like 1-100000 is refused rather than expanded
+const maxIDRange = 1000
+
+// parseIDList parses a comma-separated list of task IDs and ranges of
+// IDs such as 3,5,8-12
+func parseIDList(s string) ([]int, error) {
+	var ids []int
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		first, last := field, field
+		if dash := strings.Index(field, "-"); dash > 0 {
+			first, last = strings.TrimSpace(field[:dash]), strings.TrimSpace(field[dash+1:])
+		}
+		from, err := strconv.Atoi(first)
+		if err != nil || from <= 0 {
+			return nil, fmt.Errorf("invalid task ID %q", field)
+		}
+		to, err := strconv.Atoi(last)
+		if err != nil || to < from {
+			return nil, fmt.Errorf("invalid task ID range %q", field)
+		}
+		if to-from >= maxIDRange {
+			return nil, fmt.Errorf("task ID range %q covers more than %d tasks", field, maxIDRange)
+		}
+		for id := from; id <= to; id++ {
+			ids = append(ids, id)
+		}
+	}
+	return normalizeIDs(ids), nil
+}
+
+// normalizeIDs sorts ids and drops duplicates
+func normalizeIDs(ids []int) []int {
+	sort.Ints(ids)
+	var result []int
+	for i, id := range ids {
+		if i == 0 || id != ids[i-1] {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// topoOrder orders tasks so that every task comes after the tasks that
+// block it, preferring the original order among tasks that are free to
+// go next. Links to tasks not in the list are ignored; should the links
+// contain a cycle anyway, its tasks are appended in their original order.
+func topoOrder(tasks []Task) []Task {
+	position := map[int]int{}
+	for i, task := range tasks {
+		position[task.ID] = i
+	}
+	waiting := make([]int, len(tasks))
+	unblocks := map[int][]int{}
+	for i, task := range tasks {
+		for _, blocker := range normalizeIDs(append([]int(nil), task.BlockedBy...)) {
+			if j, ok := position[blocker]; ok {
+				waiting[i]++
+				unblocks[j] = append(unblocks[j], i)
+			}
+		}
+	}
+
+	var ready []int
+	for i := range tasks {
+		if waiting[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	ordered := make([]Task, 0, len(tasks))
+	placed := make([]bool, len(tasks))
+	for len(ready) > 0 {
+		sort.Ints(ready)
+		i := ready[0]
+		ready = ready[1:]
+		ordered = append(ordered, tasks[i])
+		placed[i] = true
+		for _, j := range unblocks[i] {
+			waiting[j]--
+			if waiting[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+	}
+	for i, task := range tasks {
+		if !placed[i] {
+			ordered = append(ordered, task)
+		}
+	}
+	return ordered
+}
+
+// setBlockers replaces the tasks blocking task id
+func setBlockers(id int, blockers []int) (*Task, error) {
+	task, err := getTask(id)
+	if err != nil {
+		return nil, err
+	}
+	all, err := allTasks()
+	if err != nil {
+		return nil, err
+	}
+	// Links already in place were checked when they were made, and may
+	// point at tasks deleted since
+	blockers = normalizeIDs(blockers)
+	var added []int
+	for _, blocker := range blockers {
+		if !containsID(task.BlockedBy, blocker) {
+			added = append(added, blocker)
+		}
+	}
+	if err := validateBlockers(all, id, added); err != nil {
+		return nil, err
+	}
+	task.BlockedBy = blockers
+	return task, updateTask(task)
+}
+
+// containsID reports whether ids contains id
+func containsID(ids []int, id int) bool {
+	for _, existing := range ids {
+		if existing == id {
+			return true
+		}
+	}
+	return false
+}
+
+// blockersOf returns the open blockers of task id
+func blockersOf(id int) ([]int, error) {
+	all, err := allTasks()
+	if err != nil {
+		return nil, err
+	}
+	index := taskIndex(all)
+	task, ok := index[id]
+	if !ok {
+		return nil, errTaskNotFound
+	}
+	return openBlockers(index, task), nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import "testing"
+
+// TestValidateBlockers tests that links creating a cycle are refused
+func TestValidateBlockers(t *testing.T) {
+	// 3 waits for 2, which waits for 1
+	tasks := []Task{
+		{ID: 1, Title: "Design"},
+		{ID: 2, Title: "Build", BlockedBy: []int{1}},
+		{ID: 3, Title: "Ship", BlockedBy: []int{2}},
+		{ID: 4, Title: "Announce"},
+	}
+
+	tests := []struct {
+		name     string
+		id       int
+		blockers []int
+		wantErr  bool
+	}{
+		{"independent", 4, []int{3}, false},
+		{"shared blocker", 4, []int{1, 2}, false},
+		{"self", 2, []int{2}, true},
+		{"missing", 4, []int{9}, true},
+		{"direct cycle", 1, []int{2}, true},
+		{"indirect cycle", 1, []int{3}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateBlockers(tasks, tt.id, tt.blockers)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateBlockers(%d, %v) error = %v; want error %v", tt.id, tt.blockers, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestTopoOrder tests that tasks come after their blockers and otherwise
+// keep their order
+func TestTopoOrder(t *testing.T) {
+	tasks := []Task{
+		{ID: 1, Title: "Ship", BlockedBy: []int{3}},
+		{ID: 2, Title: "Unrelated"},
+		{ID: 3, Title: "Build", BlockedBy: []int{4, 9}},
+		{ID: 4, Title: "Design"},
+	}
+
+	var got []int
+	for _, task := range topoOrder(tasks) {
+		got = append(got, task.ID)
+	}
+	want := []int{2, 4, 3, 1}
+	if !equalIDs(got, want) {
+		t.Errorf("topoOrder() = %v; want %v", got, want)
+	}
+}
+
+// TestParseIDList tests lists and ranges of task IDs
+func TestParseIDList(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    []int
+		wantErr bool
+	}{
+		{"3", []int{3}, false},
+		{"5, 3,5", []int{3, 5}, false},
+		{"3,5,8-12", []int{3, 5, 8, 9, 10, 11, 12}, false},
+		{"4-4", []int{4}, false},
+		{"", nil, false},
+		{"0", nil, true},
+		{"x", nil, true},
+		{"12-8", nil, true},
+		{"8-", nil, true},
+		{"1-5000", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseIDList(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseIDList(%q) error = %v; want error %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if !equalIDs(got, tt.want) {
+			t.Errorf("parseIDList(%q) = %v; want %v", tt.input, got, tt.want)
+		}
+	}
+}
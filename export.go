@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export and import formats
+const (
+	formatCSV      = "csv"
+	formatMarkdown = "md"
+	formatICal     = "ics"
+	formatTodoTxt  = "todotxt"
+)
+
+// csvHeader lists the columns written by exportCSV; parseCSV needs only
+// "title" and accepts the columns in any order
+var csvHeader = []string{"id", "title", "description", "completed", "priority", "due", "tags", "parent_id", "repeat", "state"}
+
+// formatFromPath guesses an export format from a file extension
+func formatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return formatCSV
+	case ".md", ".markdown":
+		return formatMarkdown
+	case ".ics":
+		return formatICal
+	case ".txt":
+		return formatTodoTxt
+	}
+	return ""
+}
+
+// exportTasks writes tasks to w in the given format
+func exportTasks(w io.Writer, tasks []Task, format string) error {
+	switch format {
+	case formatCSV:
+		return exportCSV(w, tasks)
+	case formatMarkdown:
+		return exportMarkdown(w, tasks)
+	case formatICal:
+		return exportICal(w, tasks, time.Now())
+	case formatTodoTxt:
+		return exportTodoTxt(w, tasks)
+	}
+	return fmt.Errorf("unknown export format %q (use csv, md, ics or todotxt)", format)
+}
+
+// exportCSV writes one row per task below a header row
+func exportCSV(w io.Writer, tasks []Task) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		due := ""
+		if task.DueDate != nil {
+			due = formatDueDate(task.DueDate)
+		}
+		repeat := ""
+		if task.Recurrence != nil {
+			repeat = task.Recurrence.String()
+		}
+		parent := ""
+		if task.ParentID != 0 {
+			parent = strconv.Itoa(task.ParentID)
+		}
+		row := []string{
+			strconv.Itoa(task.ID),
+			task.Title,
+			task.Description,
+			strconv.FormatBool(task.Completed),
+			task.Priority.String(),
+			due,
+			strings.Join(task.Tags, ","),
+			parent,
+			repeat,
+			workflow.StateOf(task),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// exportMarkdown writes a checklist, indenting subtasks under their
+// parents
+func exportMarkdown(w io.Writer, tasks []Task) error {
+	for _, node := range buildTree(tasks) {
+		mark := " "
+		if node.Task.Completed {
+			mark = "x"
+		}
+		indent := strings.Repeat("  ", node.Depth)
+		if _, err := fmt.Fprintf(w, "%s- [%s] %s\n", indent, mark, node.Task.Title); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// icalPriority maps priorities onto the iCalendar 1-9 scale, where 1 is
+// the most urgent
+var icalPriority = map[Priority]int{
+	PriorityUrgent: 1,
+	PriorityHigh:   3,
+	PriorityMedium: 5,
+	PriorityLow:    9,
+}
+
+// exportICal writes a calendar with one VTODO per task
+func exportICal(w io.Writer, tasks []Task, now time.Time) error {
+	var lines []string
+	add := func(name, value string) {
+		lines = append(lines, name+":"+value)
+	}
+	stamp := now.UTC().Format("20060102T150405Z")
+
+	add("BEGIN", "VCALENDAR")
+	add("VERSION", "2.0")
+	add("PRODID", "-//go-sample//Task Manager//EN")
+	for _, task := range tasks {
+		add("BEGIN", "VTODO")
+		add("UID", icalUID(task.ID))
+		add("DTSTAMP", stamp)
+		add("SUMMARY", icalEscape(task.Title))
+		if task.Description != "" {
+			add("DESCRIPTION", icalEscape(task.Description))
+		}
+		if task.Completed {
+			add("STATUS", "COMPLETED")
+		} else {
+			add("STATUS", "NEEDS-ACTION")
+		}
+		add("PRIORITY", strconv.Itoa(icalPriority[task.Priority]))
+		if task.DueDate != nil {
+			add("DUE", task.DueDate.UTC().Format("20060102T150405Z"))
+		}
+		if len(task.Tags) > 0 {
+			var escaped []string
+			for _, tag := range task.Tags {
+				escaped = append(escaped, icalEscape(tag))
+			}
+			add("CATEGORIES", strings.Join(escaped, ","))
+		}
+		if task.ParentID != 0 {
+			add("RELATED-TO", icalUID(task.ParentID))
+		}
+		if rule := icalRRule(task.Recurrence); rule != "" {
+			add("RRULE", rule)
+		}
+		add("END", "VTODO")
+	}
+	add("END", "VCALENDAR")
+
+	for _, line := range lines {
+		if _, err := io.WriteString(w, icalFold(line)+"\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// icalUID builds a stable identifier for a task
+func icalUID(id int) string {
+	return fmt.Sprintf("task-%d@go-sample", id)
+}
+
+// icalEscape escapes text values as RFC 5545 requires
+func icalEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// icalFold splits content lines longer than 75 octets, continuing them
+// on lines that start with a space. It never splits a UTF-8 sequence.
+func icalFold(line string) string {
+	const limit = 75
+	var b strings.Builder
+	width := 0
+	for _, r := range line {
+		size := len(string(r))
+		if width+size > limit {
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(r)
+		width += size
+	}
+	return b.String()
+}
+
+// icalDays are the RRULE names of the weekdays, starting with Sunday
+var icalDays = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// icalRRule converts a recurrence rule to an RRULE value
+func icalRRule(r *Recurrence) string {
+	if r == nil {
+		return ""
+	}
+	switch r.Kind {
+	case RepeatDaily:
+		return "FREQ=DAILY"
+	case RepeatWeekly:
+		if len(r.Weekdays) == 0 {
+			return "FREQ=WEEKLY"
+		}
+		var days []string
+		for _, day := range r.Weekdays {
+			days = append(days, icalDays[day])
+		}
+		return "FREQ=WEEKLY;BYDAY=" + strings.Join(days, ",")
+	case RepeatMonthly:
+		return fmt.Sprintf("FREQ=MONTHLY;BYMONTHDAY=%d", r.Day)
+	case RepeatInterval:
+		return fmt.Sprintf("FREQ=DAILY;INTERVAL=%d", r.Interval)
+	}
+	return ""
+}
+
+// importProblem is a line of an imported file that was skipped. For CSV
+// files the line is the record number, which differs only when quoted
+// fields span several lines.
+type importProblem struct {
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+func (p importProblem) String() string {
+	return fmt.Sprintf("line %d: %s", p.Line, p.Reason)
+}
+
+// importedTask is a parsed task with the parent reference from the file.
+// Imported tasks get fresh IDs, so parents are resolved after creation.
+type importedTask struct {
+	Task     Task
+	SourceID int
+	ParentID int
+	// Line is where the task is in the file, for reporting problems
+	Line int
+}
+
+// parseImport reads tasks in the given format
+func parseImport(r io.Reader, format string) ([]importedTask, []importProblem, error) {
+	switch format {
+	case formatCSV:
+		return parseCSV(r)
+	case formatMarkdown:
+		return parseMarkdown(r)
+	case formatTodoTxt:
+		return parseTodoTxt(r)
+	}
+	return nil, nil, fmt.Errorf("unknown import format %q (use csv, md or todotxt)", format)
+}
+
+// parseCSV reads rows written by exportCSV or any CSV file whose header
+// has a "title" column
+func parseCSV(r io.Reader) ([]importedTask, []importProblem, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading CSV header: %v", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["title"]; !ok {
+		return nil, nil, fmt.Errorf("CSV header has no title column")
+	}
+
+	var imported []importedTask
+	var problems []importProblem
+	for record := 2; ; record++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if parseErr, ok := err.(*csv.ParseError); ok {
+			problems = append(problems, importProblem{Line: parseErr.Line, Reason: parseErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return imported, problems, fmt.Errorf("error reading CSV: %v", err)
+		}
+		item, err := parseCSVRow(row, columns)
+		if err != nil {
+			problems = append(problems, importProblem{Line: record, Reason: err.Error()})
+			continue
+		}
+		item.Line = record
+		imported = append(imported, item)
+	}
+	return imported, problems, nil
+}
+
+// parseCSVRow converts one CSV row into a task
+func parseCSVRow(row []string, columns map[string]int) (importedTask, error) {
+	field := func(name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+
+	item := importedTask{Task: Task{
+		Title:       field("title"),
+		Description: field("description"),
+		Priority:    PriorityMedium,
+		Tags:        parseTagList(field("tags")),
+	}}
+	if item.Task.Title == "" {
+		return item, fmt.Errorf("title is empty")
+	}
+
+	var err error
+	if value := field("id"); value != "" {
+		if item.SourceID, err = strconv.Atoi(value); err != nil {
+			return item, fmt.Errorf("invalid id %q", value)
+		}
+	}
+	if value := field("completed"); value != "" {
+		if item.Task.Completed, err = strconv.ParseBool(value); err != nil {
+			return item, fmt.Errorf("invalid completed value %q", value)
+		}
+	}
+	if value := field("priority"); value != "" {
+		if item.Task.Priority, err = ParsePriority(value); err != nil {
+			return item, err
+		}
+	}
+	if value := field("due"); value != "" {
+		due, err := parseDueDate(value)
+		if err != nil {
+			return item, err
+		}
+		item.Task.DueDate = &due
+	}
+	if value := field("parent_id"); value != "" {
+		if item.ParentID, err = strconv.Atoi(value); err != nil {
+			return item, fmt.Errorf("invalid parent_id %q", value)
+		}
+	}
+	if value := field("repeat"); value != "" {
+		if item.Task.Recurrence, err = ParseRecurrence(value); err != nil {
+			return item, err
+		}
+	}
+	// The state decides whether the task is completed
+	if value := field("state"); value != "" {
+		if !workflow.Has(value) {
+			return item, fmt.Errorf("unknown state %q (use %s)", value, strings.Join(workflow.States, ", "))
+		}
+		item.Task.State = value
+		item.Task.Completed = workflow.IsDone(value)
+	}
+	return item, nil
+}
+
+// checklistItem matches "- [ ] Title" and "* [x] Title", capturing the
+// indentation, the mark and the title
+var checklistItem = regexp.MustCompile(`^(\s*)[-*+] \[([ xX])\] (.*)$`)
+
+// parseMarkdown reads a checklist. Headings and blank lines are
+// skipped; an item indented below another becomes its subtask.
+func parseMarkdown(r io.Reader) ([]importedTask, []importProblem, error) {
+	type level struct {
+		indent   int
+		sourceID int
+	}
+
+	var imported []importedTask
+	var problems []importProblem
+	var stack []level
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), " \t")
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		match := checklistItem.FindStringSubmatch(text)
+		if match == nil {
+			problems = append(problems, importProblem{Line: line, Reason: "not a checklist item"})
+			continue
+		}
+		title := strings.TrimSpace(match[3])
+		if title == "" {
+			problems = append(problems, importProblem{Line: line, Reason: "title is empty"})
+			continue
+		}
+
+		indent := len(strings.Replace(match[1], "\t", "    ", -1))
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		item := importedTask{
+			Task:     Task{Title: title, Completed: match[2] != " ", Priority: PriorityMedium},
+			SourceID: line,
+			Line:     line,
+		}
+		if len(stack) > 0 {
+			item.ParentID = stack[len(stack)-1].sourceID
+		}
+		stack = append(stack, level{indent: indent, sourceID: line})
+		imported = append(imported, item)
+	}
+	if err := scanner.Err(); err != nil {
+		return imported, problems, fmt.Errorf("error reading checklist: %v", err)
+	}
+	return imported, problems, nil
+}
+
+// importTasks stores parsed tasks with fresh IDs, all of them or, when
+// one fails, none. A parent reference is kept when the parent was
+// imported from the same file; others are dropped. A task that would be
+// its own parent or its own subtask stays at the top level and is
+// reported as a problem. It returns the created tasks.
+func importTasks(items []importedTask) ([]Task, []importProblem, error) {
+	var created []Task
+	var problems []importProblem
+	err := atomically(func() error {
+		newIDs := map[int]int{}
+		created = make([]Task, 0, len(items))
+		for _, item := range items {
+			task := item.Task
+			task.ID = 0
+			task.ParentID = 0
+			if err := createTask(&task); err != nil {
+				return err
+			}
+			if item.SourceID != 0 {
+				newIDs[item.SourceID] = task.ID
+			}
+			created = append(created, task)
+		}
+
+		for i, item := range items {
+			parent, ok := newIDs[item.ParentID]
+			if item.ParentID == 0 || !ok {
+				continue
+			}
+			if item.ParentID == item.SourceID {
+				problems = append(problems, importProblem{Line: item.Line, Reason: "a task cannot be its own parent; imported at the top level"})
+				continue
+			}
+			if err := validateParent(created, created[i].ID, parent); err != nil {
+				problems = append(problems, importProblem{Line: item.Line,
+					Reason: fmt.Sprintf("parent %d would make the task its own subtask; imported at the top level", item.ParentID)})
+				continue
+			}
+			created[i].ParentID = parent
+			if err := updateTask(&created[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return created, problems, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestImportTasksParents tests that parents are linked only when that
+// keeps the subtask tree a tree
+func TestImportTasksParents(t *testing.T) {
+	useStore(t, NewMemoryStore())
+	input := strings.Join([]string{
+		"id,title,parent_id",
+		"1,Plan,",
+		"2,Book flights,1",
+		"3,Own parent,3",
+		"4,Chicken,5",
+		"5,Egg,4",
+	}, "\n")
+
+	items, problems, err := parseImport(strings.NewReader(input), formatCSV)
+	if err != nil || len(problems) > 0 {
+		t.Fatalf("parseImport() = %v, %v; want no problems", problems, err)
+	}
+	created, problems, err := importTasks(items)
+	if err != nil {
+		t.Fatalf("importTasks() returned error: %v", err)
+	}
+	if len(created) != 5 {
+		t.Fatalf("importTasks() created %d tasks; want 5", len(created))
+	}
+	var lines []int
+	for _, problem := range problems {
+		lines = append(lines, problem.Line)
+	}
+	if len(lines) != 2 || lines[0] != 4 || lines[1] != 6 {
+		t.Errorf("problems = %v; want lines 4 and 6", problems)
+	}
+
+	parents := map[string]int{}
+	all, _ := allTasks()
+	for _, task := range all {
+		parents[task.Title] = task.ParentID
+	}
+	want := map[string]int{"Plan": 0, "Book flights": 1, "Own parent": 0, "Chicken": 5, "Egg": 0}
+	for title, parent := range want {
+		if parents[title] != parent {
+			t.Errorf("parent of %s = %d; want %d", title, parents[title], parent)
+		}
+	}
+	if n := len(buildTree(all)); n != 5 {
+		t.Errorf("buildTree() shows %d tasks; want 5", n)
+	}
+}
+
+// TestCSVState tests that workflow states survive a CSV round trip and
+// that states the workflow lacks are refused
+func TestCSVState(t *testing.T) {
+	saved := workflow
+	workflow = &reviewWorkflow
+	defer func() { workflow = saved }()
+
+	var buf bytes.Buffer
+	tasks := []Task{
+		{ID: 1, Title: "Write spec", State: "review"},
+		{ID: 2, Title: "Old idea", State: "dropped", Completed: true},
+		{ID: 3, Title: "Ship", Completed: true},
+	}
+	if err := exportCSV(&buf, tasks); err != nil {
+		t.Fatalf("exportCSV() returned error: %v", err)
+	}
+	buf.WriteString("4,Blocked,,false,medium,,,,,blocked\n")
+
+	items, problems, err := parseCSV(&buf)
+	if err != nil {
+		t.Fatalf("parseCSV() returned error: %v", err)
+	}
+	if len(problems) != 1 || problems[0].Line != 5 || !strings.Contains(problems[0].Reason, `unknown state "blocked"`) {
+		t.Errorf("problems = %+v; want an unknown state on line 5", problems)
+	}
+	want := []struct {
+		state     string
+		completed bool
+	}{{"review", false}, {"dropped", true}, {"done", true}}
+	if len(items) != len(want) {
+		t.Fatalf("parseCSV() returned %d tasks; want %d", len(items), len(want))
+	}
+	for i, w := range want {
+		if task := items[i].Task; task.State != w.state || task.Completed != w.completed {
+			t.Errorf("task %d state, completed = %q, %v; want %q, %v", i+1, task.State, task.Completed, w.state, w.completed)
+		}
+	}
+}
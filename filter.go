@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+Filter expressions select tasks for listing. An expression is a list of
+terms separated by spaces; a task matches when it satisfies every term.
+
+	tag:infra          the task has the tag "infra"
+	status:pending     pending, completed (or done) and overdue
+	priority:high      the task has exactly this priority
+	state:review       the task is in this workflow state
+	login              the title or description contains "login"
+	"fix login"        quoted text may contain spaces
+	-tag:blocked       a leading '-' negates any term
+*/
+
+// Filter is a parsed filter expression
+type Filter struct {
+	terms []filterTerm
+}
+
+// filterTerm is one predicate of a filter, possibly negated
+type filterTerm struct {
+	negate bool
+	match  func(task Task, now time.Time) bool
+}
+
+// ParseFilter parses a filter expression. An empty expression matches
+// every task.
+func ParseFilter(expr string) (*Filter, error) {
+	words, err := splitFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := &Filter{}
+	for _, word := range words {
+		term := filterTerm{}
+		if strings.HasPrefix(word, "-") && len(word) > 1 {
+			term.negate = true
+			word = word[1:]
+		}
+		term.match, err = parseFilterTerm(word)
+		if err != nil {
+			return nil, err
+		}
+		filter.terms = append(filter.terms, term)
+	}
+	return filter, nil
+}
+
+// parseFilterTerm turns one word of a filter into a predicate
+func parseFilterTerm(word string) (func(Task, time.Time) bool, error) {
+	colon := strings.Index(word, ":")
+	if colon < 0 {
+		return textTerm(word), nil
+	}
+	key, value := word[:colon], word[colon+1:]
+
+	switch strings.ToLower(key) {
+	case "tag":
+		if value == "" {
+			return nil, fmt.Errorf("tag: needs a tag name")
+		}
+		return func(task Task, now time.Time) bool { return task.HasTag(value) }, nil
+	case "status":
+		switch strings.ToLower(value) {
+		case "pending", "open":
+			return func(task Task, now time.Time) bool { return !task.Completed }, nil
+		case "completed", "done":
+			return func(task Task, now time.Time) bool { return task.Completed }, nil
+		case "overdue":
+			return func(task Task, now time.Time) bool { return task.IsOverdue(now) }, nil
+		}
+		return nil, fmt.Errorf("unknown status %q (use pending, completed or overdue)", value)
+	case "state":
+		state := strings.ToLower(value)
+		if !workflow.Has(state) {
+			return nil, fmt.Errorf("unknown state %q (use %s)", value, strings.Join(workflow.States, ", "))
+		}
+		return func(task Task, now time.Time) bool { return workflow.StateOf(task) == state }, nil
+	case "priority":
+		priority, err := ParsePriority(value)
+		if err != nil {
+			return nil, err
+		}
+		return func(task Task, now time.Time) bool { return task.Priority == priority }, nil
+	}
+	// Not a known key, so "a:b" is plain text to look for
+	return textTerm(word), nil
+}
+
+// textTerm matches tasks whose title or description contains text,
+// ignoring case
+func textTerm(text string) func(Task, time.Time) bool {
+	text = strings.ToLower(text)
+	return func(task Task, now time.Time) bool {
+		return strings.Contains(strings.ToLower(task.Title), text) ||
+			strings.Contains(strings.ToLower(task.Description), text)
+	}
+}
+
+// splitFilter splits an expression on spaces, keeping double-quoted
+// text together
+func splitFilter(expr string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inQuotes, hasWord := false, false
+	for _, r := range expr {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			hasWord = true
+		case (r == ' ' || r == '\t') && !inQuotes:
+			if hasWord {
+				words = append(words, word.String())
+				word.Reset()
+				hasWord = false
+			}
+		default:
+			word.WriteRune(r)
+			hasWord = true
+		}
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("unterminated quote in filter %q", expr)
+	}
+	if hasWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// Match reports whether task satisfies every term of the filter
+func (f *Filter) Match(task Task, now time.Time) bool {
+	for _, term := range f.terms {
+		if term.match(task, now) == term.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply returns the tasks that match the filter
+func (f *Filter) Apply(tasks []Task, now time.Time) []Task {
+	var matched []Task
+	for _, task := range tasks {
+		if f.Match(task, now) {
+			matched = append(matched, task)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestFilterMatch tests filter expressions against a fixed set of tasks
+func TestFilterMatch(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	past := now.Add(-24 * time.Hour)
+
+	tasks := []Task{
+		{ID: 1, Title: "Deploy cluster", Tags: []string{"infra"}},
+		{ID: 2, Title: "Rotate keys", Tags: []string{"blocked", "infra"}},
+		{ID: 3, Title: "Write guide", Description: "Fix login docs", Tags: []string{"docs"}, Completed: true},
+		{ID: 4, Title: "Pay invoice", DueDate: &past, Priority: PriorityUrgent},
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		want []int
+	}{
+		{"empty", "", []int{1, 2, 3, 4}},
+		{"tag", "tag:infra", []int{1, 2}},
+		{"negated tag", "tag:infra -tag:blocked", []int{1}},
+		{"status pending", "status:pending", []int{1, 2, 4}},
+		{"status done", "status:done", []int{3}},
+		{"overdue", "status:overdue", []int{4}},
+		{"priority", "priority:urgent", []int{4}},
+		{"text in description", "LOGIN", []int{3}},
+		{"quoted text", `"rotate keys"`, []int{2}},
+		{"negated quoted text", `-"rotate keys" tag:infra`, []int{1}},
+		{"unknown key is text", "foo:bar", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := ParseFilter(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseFilter(%q) returned error: %v", tt.expr, err)
+			}
+			var got []int
+			for _, task := range filter.Apply(tasks, now) {
+				got = append(got, task.ID)
+			}
+			if !equalIDs(got, tt.want) {
+				t.Errorf("ParseFilter(%q) matched %v; want %v", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseFilterErrors tests that malformed expressions are rejected
+func TestParseFilterErrors(t *testing.T) {
+	for _, expr := range []string{`"unterminated`, "status:later", "priority:none", "tag:"} {
+		if _, err := ParseFilter(expr); err == nil {
+			t.Errorf("ParseFilter(%q) returned no error", expr)
+		}
+	}
+}
+
+// equalIDs compares two ID lists element by element
+func equalIDs(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
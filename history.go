@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// historyLimit bounds how many commands can be undone
+const historyLimit = 50
+
+var (
+	errNothingToUndo = errors.New("nothing to undo")
+	errNothingToRedo = errors.New("nothing to redo")
+)
+
+// change is one task before and after an operation. A nil Before means
+// the task was created, a nil After that it was deleted. An empty
+// Project is the default project, as in histories written before
+// projects existed.
+type change struct {
+	Project string `json:"project,omitempty"`
+	Before  *Task  `json:"before,omitempty"`
+	After   *Task  `json:"after,omitempty"`
+}
+
+// project returns the project the changed task belongs to
+func (c change) project() string {
+	if c.Project == "" {
+		return defaultProject
+	}
+	return c.Project
+}
+
+// command is a reversible user action, such as one menu choice or one
+// subcommand, made up of all the task changes it caused
+type command struct {
+	Name    string    `json:"name"`
+	Time    time.Time `json:"time"`
+	Changes []change  `json:"changes"`
+}
+
+// History holds the commands that can be undone and redone
+type History struct {
+	Undo []command `json:"undo"`
+	Redo []command `json:"redo"`
+
+	path    string
+	pending *command
+}
+
+// history records the commands of this session; when it has a path it
+// is saved there so undo also works after a restart
+var history = &History{}
+
+// loadHistory reads the history file at path. A missing file starts an
+// empty history.
+func loadHistory(path string) (*History, error) {
+	h := &History{path: path}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, fmt.Errorf("%s is corrupt: %v", path, err)
+	}
+	return h, nil
+}
+
+// save writes the history to its file, if it has one
+func (h *History) save() error {
+	if h.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding history: %v", err)
+	}
+
+	if err := replaceFile(h.path, data); err != nil {
+		return fmt.Errorf("error saving history: %v", err)
+	}
+	return nil
+}
+
+// Begin starts recording the changes made by the command name
+func (h *History) Begin(name string) {
+	h.pending = &command{Name: name, Time: time.Now()}
+}
+
+// End finishes the current command. Commands that changed nothing are
+// dropped; any other command clears the redo list.
+func (h *History) End() error {
+	cmd := h.pending
+	h.pending = nil
+	if cmd == nil || len(cmd.Changes) == 0 {
+		return nil
+	}
+	h.Undo = append(h.Undo, *cmd)
+	if len(h.Undo) > historyLimit {
+		h.Undo = h.Undo[len(h.Undo)-historyLimit:]
+	}
+	h.Redo = nil
+	return h.save()
+}
+
+// record adds a change to a task of project to the command being
+// recorded, if any
+func (h *History) record(project string, before, after *Task) {
+	if h.pending == nil {
+		return
+	}
+	if project == defaultProject {
+		project = ""
+	}
+	h.pending.Changes = append(h.pending.Changes, change{Project: project, Before: snapshot(before), After: snapshot(after)})
+}
+
+// renameProject points the recorded changes of project oldName at
+// newName, so they can still be undone
+func (h *History) renameProject(oldName, newName string) error {
+	renamed := false
+	for _, commands := range [][]command{h.Undo, h.Redo} {
+		for i := range commands {
+			for j := range commands[i].Changes {
+				if commands[i].Changes[j].Project == oldName {
+					commands[i].Changes[j].Project = newName
+					renamed = true
+				}
+			}
+		}
+	}
+	if !renamed {
+		return nil
+	}
+	return h.save()
+}
+
+// mark returns a position in the command being recorded that discard
+// can go back to
+func (h *History) mark() int {
+	if h.pending == nil {
+		return 0
+	}
+	return len(h.pending.Changes)
+}
+
+// discard forgets the changes recorded since mark, which were rolled
+// back
+func (h *History) discard(mark int) {
+	if h.pending != nil && mark < len(h.pending.Changes) {
+		h.pending.Changes = h.pending.Changes[:mark]
+	}
+}
+
+// recording reports whether a command is being recorded
+func (h *History) recording() bool {
+	return h.pending != nil
+}
+
+// snapshot copies a task so later edits do not change the record
+func snapshot(task *Task) *Task {
+	if task == nil {
+		return nil
+	}
+	copied := *task
+	copied.Tags = append([]string(nil), task.Tags...)
+	copied.TimeEntries = append([]TimeEntry(nil), task.TimeEntries...)
+	copied.BlockedBy = append([]int(nil), task.BlockedBy...)
+	copied.Extra = append([]string(nil), task.Extra...)
+	return &copied
+}
+
+// UndoLast reverts the most recent command and returns it
+func (h *History) UndoLast() (*command, error) {
+	if len(h.Undo) == 0 {
+		return nil, errNothingToUndo
+	}
+	cmd := h.Undo[len(h.Undo)-1]
+
+	reversed := make([]change, len(cmd.Changes))
+	for i, c := range cmd.Changes {
+		reversed[len(cmd.Changes)-1-i] = change{Project: c.Project, Before: c.After, After: c.Before}
+	}
+	if err := applyChanges(reversed); err != nil {
+		return nil, fmt.Errorf("cannot undo %q: %v", cmd.Name, err)
+	}
+
+	h.Undo = h.Undo[:len(h.Undo)-1]
+	h.Redo = append(h.Redo, cmd)
+	return &cmd, h.save()
+}
+
+// RedoLast applies the most recently undone command again and returns it
+func (h *History) RedoLast() (*command, error) {
+	if len(h.Redo) == 0 {
+		return nil, errNothingToRedo
+	}
+	cmd := h.Redo[len(h.Redo)-1]
+
+	if err := applyChanges(cmd.Changes); err != nil {
+		return nil, fmt.Errorf("cannot redo %q: %v", cmd.Name, err)
+	}
+
+	h.Redo = h.Redo[:len(h.Redo)-1]
+	h.Undo = append(h.Undo, cmd)
+	return &cmd, h.save()
+}
+
+// applyChanges moves every task from its Before state to its After
+// state. It first checks that each task is still in its Before state,
+// so a task someone changed in the meantime is never overwritten. The
+// changes to each project are made atomically, one project after the
+// other.
+func applyChanges(changes []change) error {
+	// Later changes to the same task start from the state the earlier
+	// ones leave behind, so only each task's first Before is checked
+	type key struct {
+		project string
+		id      int
+	}
+	checked := map[key]bool{}
+	for _, c := range changes {
+		id := changeID(c)
+		if checked[key{c.project(), id}] {
+			continue
+		}
+		checked[key{c.project(), id}] = true
+
+		s, err := projectStore(c.project())
+		if err != nil {
+			return err
+		}
+		current, err := s.Get(id)
+		if err == errTaskNotFound {
+			current = nil
+		} else if err != nil {
+			return err
+		}
+		if !sameTask(current, c.Before) {
+			return fmt.Errorf("task %d has changed since", id)
+		}
+	}
+
+	var order []string
+	byProject := map[string][]change{}
+	for _, c := range changes {
+		if _, ok := byProject[c.project()]; !ok {
+			order = append(order, c.project())
+		}
+		byProject[c.project()] = append(byProject[c.project()], c)
+	}
+	for _, project := range order {
+		err := atomicallyIn(project, func() error {
+			for _, c := range byProject[project] {
+				if err := applyChange(c); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyChange moves one task from its Before state to its After state
+func applyChange(c change) error {
+	switch {
+	case c.Before == nil:
+		after := *c.After
+		return createTaskIn(c.project(), &after)
+	case c.After == nil:
+		return removeTaskIn(c.project(), c.Before.ID)
+	}
+	after := *c.After
+	return updateTaskIn(c.project(), &after)
+}
+
+// changeID returns the ID of the task a change is about
+func changeID(c change) int {
+	if c.Before != nil {
+		return c.Before.ID
+	}
+	return c.After.ID
+}
+
+// sameTask compares two task states by their stored form
+func sameTask(a, b *Task) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(encodedA, encodedB)
+}
+
+// describe summarises a command for messages, e.g. "Delete Task (tasks 3, 4)"
+func (c command) describe() string {
+	var ids []string
+	seen := map[int]bool{}
+	for _, ch := range c.Changes {
+		id := changeID(ch)
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, fmt.Sprint(id))
+		}
+	}
+	noun := "task"
+	if len(ids) > 1 {
+		noun = "tasks"
+	}
+	return fmt.Sprintf("%s (%s %s)", c.Name, noun, strings.Join(ids, ", "))
+}
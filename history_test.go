@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// TestHistoryUndoRedo tests that a command is undone and redone as a
+// whole and that the history file keeps it across restarts
+func TestHistoryUndoRedo(t *testing.T) {
+	useStore(t, NewMemoryStore())
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tasks.json.history")
+	saved := history
+	history = &History{path: path}
+	defer func() { history = saved }()
+
+	history.Begin("Add Tasks")
+	for _, title := range []string{"One", "Two"} {
+		if err := createTask(&Task{Title: title}); err != nil {
+			t.Fatalf("createTask() returned error: %v", err)
+		}
+	}
+	if err := history.End(); err != nil {
+		t.Fatalf("End() returned error: %v", err)
+	}
+
+	reloaded, err := loadHistory(path)
+	if err != nil {
+		t.Fatalf("loadHistory() returned error: %v", err)
+	}
+	if len(reloaded.Undo) != 1 || len(reloaded.Undo[0].Changes) != 2 {
+		t.Fatalf("reloaded history = %+v; want one command of two changes", reloaded.Undo)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("%d files after saving the history; want only the history", len(files))
+	}
+
+	if _, err := history.UndoLast(); err != nil {
+		t.Fatalf("UndoLast() returned error: %v", err)
+	}
+	if tasks, _ := allTasks(); len(tasks) != 0 {
+		t.Errorf("tasks after UndoLast() = %+v; want none", tasks)
+	}
+	if _, err := history.RedoLast(); err != nil {
+		t.Fatalf("RedoLast() returned error: %v", err)
+	}
+	if tasks, _ := allTasks(); len(tasks) != 2 {
+		t.Errorf("tasks after RedoLast() = %+v; want One and Two", tasks)
+	}
+
+	// A task changed since cannot be undone, and nothing else is
+	if err := updateTask(&Task{ID: 2, Title: "Two, edited"}); err != nil {
+		t.Fatalf("updateTask() returned error: %v", err)
+	}
+	if _, err := history.UndoLast(); err == nil {
+		t.Errorf("UndoLast() of a changed task returned no error")
+	}
+	if tasks, _ := allTasks(); len(tasks) != 2 {
+		t.Errorf("tasks after a refused UndoLast() = %+v; want both", tasks)
+	}
+}
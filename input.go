@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// errInvalidNumber is returned by Input.Int when the line is not a number
+var errInvalidNumber = errors.New("please enter a valid number")
+
+// Input reads user answers one line at a time, so answers may contain
+// spaces and never leak into the next prompt
+type Input struct {
+	r   *bufio.Reader
+	eof bool
+}
+
+// NewInput creates an Input reading from r
+func NewInput(r io.Reader) *Input {
+	return &Input{r: bufio.NewReader(r)}
+}
+
+// input is the reader used by every interactive prompt
+var input = NewInput(os.Stdin)
+
+// readLine returns the next line without its line ending. Once the end
+// of input has been reached every further call returns io.EOF, even on
+// a terminal where the user could keep typing after Ctrl-D.
+func (in *Input) readLine() (string, error) {
+	if in.eof {
+		return "", io.EOF
+	}
+	line, err := in.r.ReadString('\n')
+	if err == io.EOF {
+		in.eof = true
+		if line == "" {
+			return "", io.EOF
+		}
+	} else if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Line prints prompt and returns the answer with surrounding whitespace
+// trimmed
+func (in *Input) Line(prompt string) (string, error) {
+	fmt.Print(prompt)
+	line, err := in.readLine()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// Int prints prompt and parses the answer as an integer
+func (in *Input) Int(prompt string) (int, error) {
+	line, err := in.Line(prompt)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(line)
+	if err != nil {
+		return 0, errInvalidNumber
+	}
+	return n, nil
+}
+
+// Multiline prints prompt and reads lines until a blank line or the end
+// of input. Trailing whitespace is removed from every line; indentation
+// is kept.
+func (in *Input) Multiline(prompt string) (string, error) {
+	fmt.Print(prompt)
+	var lines []string
+	for {
+		line, err := in.readLine()
+		if err == io.EOF && len(lines) > 0 {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, " \t")
+		if line == "" {
+			break
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Confirm prints prompt and reports whether the answer is yes
+func (in *Input) Confirm(prompt string) (bool, error) {
+	answer, err := in.Line(prompt)
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+)
+
+// Event types, named after what a change did to a task
+const (
+	eventAdd       = "add"
+	eventEdit      = "edit"
+	eventComplete  = "complete"
+	eventReopen    = "reopen"
+	eventArchive   = "archive"
+	eventUnarchive = "unarchive"
+	eventTrash     = "trash"
+	eventRestore   = "restore"
+	eventDelete    = "delete"
+	// eventSnapshot stands for the changes folded into a snapshot by
+	// compaction; its task is the state they left behind
+	eventSnapshot = "snapshot"
+)
+
+// Event is one change to a task: who made it, when, and the task as it
+// was afterwards, which is nil once the task has been deleted
+type Event struct {
+	Seq  int       `json:"seq"`
+	Time time.Time `json:"time"`
+	User string    `json:"user,omitempty"`
+	Type string    `json:"type"`
+	ID   int       `json:"id"`
+	Task *Task     `json:"task,omitempty"`
+}
+
+// EventLog is implemented by stores that record every change to their
+// tasks
+type EventLog interface {
+	// Events returns the changes to task id, oldest first
+	Events(id int) ([]Event, error)
+}
+
+// Compacter is implemented by stores whose event log can be folded into
+// a snapshot of the tasks
+type Compacter interface {
+	// Compact folds the events into the snapshot and returns how many
+	// there were
+	Compact() (int, error)
+}
+
+// journalUser is who the events of this process are recorded for
+var journalUser = currentUser()
+
+// currentUser returns the name of the user running the program
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// newEvent describes the change of a task from before to after, either
+// of which may be nil
+func newEvent(before, after *Task) Event {
+	event := Event{Time: time.Now(), User: journalUser, Type: eventType(before, after), Task: snapshot(after)}
+	if after != nil {
+		event.ID = after.ID
+	} else {
+		event.ID = before.ID
+	}
+	return event
+}
+
+// eventType names the change of a task from before to after
+func eventType(before, after *Task) string {
+	switch {
+	case before == nil:
+		return eventAdd
+	case after == nil:
+		return eventDelete
+	case before.DeletedAt == nil && after.DeletedAt != nil:
+		return eventTrash
+	case before.DeletedAt != nil && after.DeletedAt == nil:
+		return eventRestore
+	case before.ArchivedAt == nil && after.ArchivedAt != nil:
+		return eventArchive
+	case before.ArchivedAt != nil && after.ArchivedAt == nil:
+		return eventUnarchive
+	case !before.Completed && after.Completed:
+		return eventComplete
+	case before.Completed && !after.Completed:
+		return eventReopen
+	}
+	return eventEdit
+}
+
+// readJournal reads the events in the journal at path, oldest first,
+// and returns them with the length of the file they take up. A last
+// line cut short by a crash is left out of both; a missing file has no
+// events.
+func readJournal(path string) ([]Event, int64, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	defer file.Close()
+
+	var events []Event
+	var size int64
+	r := bufio.NewReader(file)
+	for line := 1; ; line++ {
+		data, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// Only a complete line ends with a newline
+			return events, size, nil
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("error reading %s: %v", path, err)
+		}
+		var event Event
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, 0, fmt.Errorf("%s is corrupt at line %d: %v", path, line, err)
+		}
+		events = append(events, event)
+		size += int64(len(data))
+	}
+}
+
+// appendJournal adds events to the end of the journal at path, which is
+// created if need be, and waits until they are on disk
+func appendJournal(path string, events []Event) error {
+	var buf bytes.Buffer
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("error encoding event: %v", err)
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", path, err)
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+	return file.Close()
+}
+
+// truncateJournal cuts the journal at path back to size bytes, dropping
+// a line a crash cut short so that new events start on a line of their
+// own
+func truncateJournal(path string, size int64) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) || (err == nil && info.Size() == size) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", path, err)
+	}
+	if err := os.Truncate(path, size); err != nil {
+		return fmt.Errorf("error repairing %s: %v", path, err)
+	}
+	return nil
+}
+
+// taskHistory returns the recorded changes to task id of the current
+// project, oldest first
+func taskHistory(id int) ([]Event, error) {
+	log, ok := store.(EventLog)
+	if !ok {
+		return nil, fmt.Errorf("this storage keeps no history of changes")
+	}
+	events, err := log.Events(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		// Tasks in the trash have a history too
+		if _, err := store.Get(id); err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+// taskEvents returns the events of task id among events
+func taskEvents(events []Event, id int) []Event {
+	var result []Event
+	for _, event := range events {
+		if event.ID == id {
+			result = append(result, event)
+		}
+	}
+	return result
+}
+
+// describeEvent says what event did, given the task as it was before
+func describeEvent(event Event, before *Task) string {
+	switch event.Type {
+	case eventAdd:
+		return fmt.Sprintf("added %q", event.Task.Title)
+	case eventComplete:
+		return "completed"
+	case eventReopen:
+		return "reopened"
+	case eventArchive:
+		return "archived"
+	case eventUnarchive:
+		return "unarchived"
+	case eventTrash:
+		return "moved to the trash"
+	case eventRestore:
+		return "restored from the trash"
+	case eventDelete:
+		return "deleted for good"
+	case eventSnapshot:
+		return fmt.Sprintf("%q as of compaction; earlier changes are not kept", event.Task.Title)
+	}
+	if before == nil || event.Task == nil {
+		return "edited"
+	}
+	changes := diffTasks(*before, *event.Task)
+	if len(changes) == 0 {
+		return "edited"
+	}
+	parts := make([]string, len(changes))
+	for i, change := range changes {
+		parts[i] = change.String()
+	}
+	return "edited: " + strings.Join(parts, "; ")
+}
+
+// printTimeline writes the events of a task, oldest first, one line
+// each
+func printTimeline(w io.Writer, id int, events []Event) {
+	fmt.Fprintf(w, "History of task %d\n", id)
+	if len(events) == 0 {
+		fmt.Fprintln(w, "  No changes have been recorded for this task.")
+		return
+	}
+	var before *Task
+	for _, event := range events {
+		who := event.User
+		if who == "" {
+			who = "-"
+		}
+		fmt.Fprintf(w, "  %s  %-10s %s\n", event.Time.Local().Format("2006-01-02 15:04:05"), who, describeEvent(event, before))
+		before = event.Task
+	}
+}
+
+// readSnapshot reads the snapshot file at path; a missing file is an
+// empty snapshot
+func readSnapshot(path string) (taskFile, error) {
+	var file taskFile
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return file, nil
+	}
+	if err != nil {
+		return file, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return file, fmt.Errorf("%s is corrupt: %v", path, err)
+	}
+	return file, nil
+}
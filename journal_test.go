@@ -0,0 +1,229 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestFileStoreEvents runs the event log tests against FileStore
+func TestFileStoreEvents(t *testing.T) {
+	store, err := NewFileStore(filepath.Join(t.TempDir(), "tasks.json"))
+	if err != nil {
+		t.Fatalf("NewFileStore() returned error: %v", err)
+	}
+	testEventLog(t, store)
+}
+
+// TestDatabaseEvents runs the event log tests against Database
+func TestDatabaseEvents(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "tasks.db"))
+	if err != nil {
+		t.Fatalf("NewDatabase() returned error: %v", err)
+	}
+	defer db.Close()
+	if err := db.InitSchema(); err != nil {
+		t.Fatalf("InitSchema() returned error: %v", err)
+	}
+	testEventLog(t, db)
+}
+
+// testEventLog checks that every change to a task is recorded, and that
+// changes rolled back by Atomic are not
+func testEventLog(t *testing.T, s interface {
+	TaskStore
+	EventLog
+}) {
+	task := &Task{Title: "Draft"}
+	s.Create(task)
+	s.Create(&Task{Title: "Other"})
+	task.Title = "Final"
+	s.Update(task)
+	task.Completed = true
+	s.Update(task)
+	s.Atomic(func(tx TaskStore) error {
+		tx.Delete(task.ID)
+		return errTaskNotFound
+	})
+	s.Delete(task.ID)
+
+	events, err := s.Events(task.ID)
+	if err != nil {
+		t.Fatalf("Events() returned error: %v", err)
+	}
+	want := []string{eventAdd, eventEdit, eventComplete, eventDelete}
+	if len(events) != len(want) {
+		t.Fatalf("Events() returned %d events; want %d: %+v", len(events), len(want), events)
+	}
+	for i, event := range events {
+		if event.Type != want[i] || event.ID != task.ID {
+			t.Errorf("event %d = %s of task %d; want %s of task %d", i, event.Type, event.ID, want[i], task.ID)
+		}
+	}
+	if events[1].Task == nil || events[1].Task.Title != "Final" {
+		t.Errorf("edit event task = %+v; want title Final", events[1].Task)
+	}
+	if events[3].Task != nil {
+		t.Errorf("delete event task = %+v; want none", events[3].Task)
+	}
+}
+
+// TestFileStoreReplay tests that the journal rebuilds the tasks, also
+// after compaction and when its last line was cut short
+func TestFileStoreReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.json")
+	first, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() returned error: %v", err)
+	}
+	for _, title := range []string{"One", "Two", "Three"} {
+		first.Create(&Task{Title: title})
+	}
+	first.Update(&Task{ID: 1, Title: "One, edited"})
+	first.Delete(2)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("changes rewrote %s; want them only in the journal", path)
+	}
+
+	reload := func() *FileStore {
+		t.Helper()
+		s, err := NewFileStore(path)
+		if err != nil {
+			t.Fatalf("NewFileStore() returned error: %v", err)
+		}
+		tasks, _ := s.List(ListOptions{})
+		if len(tasks) != 2 || tasks[0].Title != "One, edited" || tasks[1].ID != 3 {
+			t.Fatalf("tasks after reload = %+v; want One, edited and Three", tasks)
+		}
+		return s
+	}
+	reload()
+
+	// A crash while appending leaves half a line behind
+	journal, err := os.OpenFile(journalPath(path), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("opening journal: %v", err)
+	}
+	journal.WriteString(`{"seq":99,"type":"ad`)
+	journal.Close()
+	second := reload()
+
+	if n, err := second.Compact(); err != nil || n != 5 {
+		t.Fatalf("Compact() = %d, %v; want 5 events", n, err)
+	}
+	if _, err := os.Stat(journalPath(path)); !os.IsNotExist(err) {
+		t.Errorf("journal still exists after Compact()")
+	}
+	third := reload()
+	task := &Task{Title: "Four"}
+	if err := third.Create(task); err != nil || task.ID != 4 {
+		t.Errorf("Create() after Compact() assigned ID %d, %v; want 4", task.ID, err)
+	}
+
+	events, err := third.Events(1)
+	if err != nil {
+		t.Fatalf("Events() returned error: %v", err)
+	}
+	if len(events) != 1 || events[0].Type != eventSnapshot || events[0].Task.Title != "One, edited" {
+		t.Errorf("Events(1) after Compact() = %+v; want the snapshot state", events)
+	}
+}
+
+// TestFileStoreFailedAppend tests that a change the journal could not
+// take is undone, so the tasks in memory match those on disk
+func TestFileStoreFailedAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.json")
+	s, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() returned error: %v", err)
+	}
+	if err := s.Create(&Task{Title: "One"}); err != nil {
+		t.Fatalf("Create() returned error: %v", err)
+	}
+
+	// A directory in place of the journal makes every append fail
+	if err := os.Rename(journalPath(path), journalPath(path)+".saved"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(journalPath(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	task := &Task{Title: "Two"}
+	if err := s.Create(task); err == nil {
+		t.Errorf("Create() returned no error")
+	}
+	if task.ID != 0 {
+		t.Errorf("ID after a failed Create() = %d; want 0", task.ID)
+	}
+	if err := s.Update(&Task{ID: 1, Title: "One, edited"}); err == nil {
+		t.Errorf("Update() returned no error")
+	}
+	if err := s.Delete(1); err == nil {
+		t.Errorf("Delete() returned no error")
+	}
+	tasks, _ := s.List(ListOptions{})
+	if len(tasks) != 1 || tasks[0].Title != "One" {
+		t.Errorf("tasks after failed changes = %+v; want only One, unchanged", tasks)
+	}
+
+	if err := os.Remove(journalPath(path)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(journalPath(path)+".saved", journalPath(path)); err != nil {
+		t.Fatal(err)
+	}
+	task = &Task{Title: "Two"}
+	if err := s.Create(task); err != nil || task.ID != 2 {
+		t.Fatalf("Create() afterwards assigned ID %d, %v; want 2", task.ID, err)
+	}
+	reloaded, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() returned error: %v", err)
+	}
+	events, err := reloaded.Events(2)
+	if err != nil || len(events) != 1 || events[0].Seq != 2 {
+		t.Errorf("Events(2) = %+v, %v; want one event with seq 2", events, err)
+	}
+}
+
+// TestFileStoreCompactKeepsOtherWriters tests that compacting keeps the
+// changes another store appended to the journal after this one loaded it
+func TestFileStoreCompactKeepsOtherWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.json")
+	setup, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() returned error: %v", err)
+	}
+	for _, title := range []string{"One", "Two"} {
+		setup.Create(&Task{Title: title})
+	}
+
+	first, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() returned error: %v", err)
+	}
+	second, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() returned error: %v", err)
+	}
+	if err := first.Update(&Task{ID: 1, Title: "One, edited"}); err != nil {
+		t.Fatalf("Update() returned error: %v", err)
+	}
+	if err := second.Update(&Task{ID: 2, Title: "Two, edited"}); err != nil {
+		t.Fatalf("Update() returned error: %v", err)
+	}
+	if n, err := first.Compact(); err != nil || n != 4 {
+		t.Fatalf("Compact() = %d, %v; want 4 events", n, err)
+	}
+
+	reloaded, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() returned error: %v", err)
+	}
+	for _, s := range []*FileStore{first, reloaded} {
+		tasks, _ := s.List(ListOptions{})
+		if len(tasks) != 2 || tasks[0].Title != "One, edited" || tasks[1].Title != "Two, edited" {
+			t.Errorf("tasks after Compact() = %+v; want both edits", tasks)
+		}
+	}
+}
@@ -1,21 +1,273 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"os/exec"
 	"runtime"
+	"strconv"
+	"strings"
+	"time"
 )
 
-type Task struct {
-	ID          int
-	Title       string
-	Description string
-	Completed   bool
+// dataFile is the JSON file tasks are loaded from and saved to
+var dataFile = "tasks.json"
+
+// remindLeads and notifyCommand configure reminders: the lead times
+// before a due date and an optional command that delivers them
+var remindLeads = defaultLeadTimes
+var notifyCommand string
+
+// storagePath is the JSON file or database the tasks are kept in. Files
+// such as the undo history and the reminder state are kept next to it.
+var storagePath = dataFile
+
+// store holds the tasks of the current project; openStorage picks the
+// JSON file or, with -db, the SQLite database
+var store TaskStore = NewMemoryStore()
+
+// createTask stores a new task in the current project and assigns its
+// ID. A task that already has an ID, as when a deletion is undone, keeps
+// it.
+func createTask(task *Task) error {
+	return createTaskIn(currentProject, task)
+}
+
+// getTask looks up a task of the current project by ID. Tasks in the
+// trash are not found.
+func getTask(id int) (*Task, error) {
+	task, err := store.Get(id)
+	if err == nil && task.DeletedAt != nil {
+		return nil, errTaskNotFound
+	}
+	return task, err
+}
+
+// updateTask replaces the stored task with the same ID
+func updateTask(task *Task) error {
+	return updateTaskIn(currentProject, task)
+}
+
+// removeTask deletes the task with the given ID
+func removeTask(id int) error {
+	return removeTaskIn(currentProject, id)
+}
+
+// createTaskIn stores a new task in project. A task without an ID is
+// new and records when it was created, unless it already has a creation
+// time, as a moved task does.
+func createTaskIn(project string, task *Task) error {
+	s, err := projectStore(project)
+	if err != nil {
+		return err
+	}
+	if task.ID == 0 && task.CreatedAt == nil {
+		now := time.Now()
+		task.CreatedAt = &now
+	}
+	if err := s.Create(task); err != nil {
+		return err
+	}
+	history.record(project, nil, task)
+	return nil
+}
+
+// updateTaskIn replaces the task with the same ID in project
+func updateTaskIn(project string, task *Task) error {
+	s, err := projectStore(project)
+	if err != nil {
+		return err
+	}
+	var before *Task
+	if history.recording() {
+		if before, err = s.Get(task.ID); err != nil {
+			return err
+		}
+	}
+
+	if err := s.Update(task); err != nil {
+		return err
+	}
+	history.record(project, before, task)
+	return nil
+}
+
+// removeTaskIn deletes the task with the given ID from project
+func removeTaskIn(project string, id int) error {
+	s, err := projectStore(project)
+	if err != nil {
+		return err
+	}
+	before, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Delete(id); err != nil {
+		return err
+	}
+	history.record(project, before, nil)
+	return nil
+}
+
+// allTasks returns a copy of every task of the current project that is
+// not in the trash, archived ones included, ordered by ID
+func allTasks() ([]Task, error) {
+	return store.List(ListOptions{Archived: true})
+}
+
+// subtasksOf returns every task below id in the subtask tree
+func subtasksOf(id int) ([]Task, error) {
+	all, err := allTasks()
+	if err != nil {
+		return nil, err
+	}
+	return descendants(all, id), nil
+}
+
+// openSubtasks returns the subtasks of id that are not completed yet
+func openSubtasks(id int) ([]Task, error) {
+	subtasks, err := subtasksOf(id)
+	if err != nil {
+		return nil, err
+	}
+	var open []Task
+	for _, task := range subtasks {
+		if !task.Completed {
+			open = append(open, task)
+		}
+	}
+	return open, nil
+}
+
+// markCompleted completes task id and, when cascade is set, every open
+// subtask below it, provided the workflow lets all of them be
+// completed. Completing a recurring task creates its next occurrence,
+// which is returned as next. A task that is completed already keeps the
+// time it was completed at.
+func markCompleted(id int, cascade bool) (task, next *Task, err error) {
+	task, err = getTask(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkComplete(*task); err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	if cascade {
+		open, err := openSubtasks(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Check them all first, so that none is completed when one
+		// cannot be
+		for _, sub := range open {
+			if err := checkComplete(sub); err != nil {
+				return nil, nil, err
+			}
+		}
+		for i := range open {
+			if _, err := completeOne(&open[i], now); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if task.Completed {
+		return task, nil, nil
+	}
+	next, err = completeOne(task, now)
+	return task, next, err
+}
+
+// completeOne marks task completed, in the first done state unless it
+// is in one already, and stops its timer. For a recurring task it also
+// creates the next occurrence, due at the next time the rule allows
+// after both the old due date and now; the rule moves to the new task
+// so that the completed one cannot spawn another copy.
+func completeOne(task *Task, now time.Time) (*Task, error) {
+	rule := task.Recurrence
+	task.Completed = true
+	task.CompletedAt = &now
+	if !workflow.IsDone(task.State) {
+		task.State = workflow.Done[0]
+	}
+	if task.Running() {
+		stopEntry(task, now)
+	}
+	if rule == nil {
+		return nil, updateTask(task)
+	}
+
+	from := now
+	if task.DueDate != nil {
+		from = *task.DueDate
+	}
+	due := rule.NextAfter(from, now)
+	next := *task
+	next.ID = 0
+	next.Completed = false
+	next.State = ""
+	next.CreatedAt = nil
+	next.CompletedAt = nil
+	next.DueDate = &due
+	next.Tags = append([]string(nil), task.Tags...)
+	next.Extra = append([]string(nil), task.Extra...)
+	next.TimeEntries = nil
+
+	task.Recurrence = nil
+	if err := updateTask(task); err != nil {
+		return nil, err
+	}
+	if err := createTask(&next); err != nil {
+		return nil, err
+	}
+	return &next, nil
+}
+
+// deleteWithSubtasks moves task id to the trash. A task that has
+// subtasks is only deleted when cascade is set, and then its subtasks go
+// with it.
+func deleteWithSubtasks(id int, cascade bool) error {
+	task, err := getTask(id)
+	if err != nil {
+		return err
+	}
+	subtasks, err := subtasksOf(id)
+	if err != nil {
+		return err
+	}
+	if len(subtasks) > 0 && !cascade {
+		return fmt.Errorf("task %d has %d subtask(s); delete them too or move them first", id, len(subtasks))
+	}
+	// Deepest first, so a failure never leaves orphaned subtasks behind
+	now := time.Now()
+	for i := len(subtasks) - 1; i >= 0; i-- {
+		if err := trashTask(&subtasks[i], now); err != nil {
+			return err
+		}
+	}
+	return trashTask(task, now)
 }
 
-var tasks []Task
-var currentID = 1
+// confirmDueDate parses a due date the user typed and shows how it was
+// understood, so a misread phrase is caught before it is saved. ok is
+// false when the date is invalid or the user rejects it.
+func confirmDueDate(answer string) (due time.Time, ok bool) {
+	due, err := parseDueDate(answer)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return time.Time{}, false
+	}
+	ok, err = input.Confirm(fmt.Sprintf("Due %s. Is that right? (y/N): ", describeDate(due)))
+	if err != nil {
+		return time.Time{}, false
+	}
+	if !ok {
+		fmt.Println("Nothing saved; please try again with a different date.")
+	}
+	return due, ok
+}
 
 func clearScreen() error {
 	var cmd *exec.Cmd
@@ -29,122 +281,1522 @@ func clearScreen() error {
 }
 
 func addTask() {
-	var title, description string
-	fmt.Print("Enter task title: ")
-	fmt.Scanln(&title)
-	fmt.Print("Enter task description: ")
-	fmt.Scanln(&description)
-
+	title, err := input.Line("Enter task title: ")
+	if err != nil {
+		return
+	}
 	if title == "" {
 		fmt.Println("Error: Title cannot be empty!")
 		return
 	}
+	description, err := input.Multiline("Enter task description (finish with an empty line):\n")
+	if err != nil {
+		return
+	}
+
+	priorityAnswer, err := input.Line("Enter priority (low/medium/high/urgent) [medium]: ")
+	if err != nil {
+		return
+	}
+	dueAnswer, err := input.Line("Enter due date (e.g. tomorrow 5pm, next friday, 2024-06-14; empty for none): ")
+	if err != nil {
+		return
+	}
+	tagAnswer, err := input.Line("Enter tags (comma separated, optional): ")
+	if err != nil {
+		return
+	}
+	parentAnswer, err := input.Line("Enter parent task ID (empty for a top-level task): ")
+	if err != nil {
+		return
+	}
+	repeatAnswer, err := input.Line("Repeat (daily, weekly:mon,thu, monthly:15, every 3 days; empty for none): ")
+	if err != nil {
+		return
+	}
 
 	task := Task{
-		ID:          currentID,
 		Title:       title,
 		Description: description,
 		Completed:   false,
+		Priority:    PriorityMedium,
+		Tags:        parseTagList(tagAnswer),
+	}
+	if priorityAnswer != "" {
+		task.Priority, err = ParsePriority(priorityAnswer)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+	if dueAnswer != "" {
+		due, ok := confirmDueDate(dueAnswer)
+		if !ok {
+			return
+		}
+		task.DueDate = &due
+	}
+	if repeatAnswer != "" {
+		task.Recurrence, err = ParseRecurrence(repeatAnswer)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+	if parentAnswer != "" {
+		task.ParentID, err = strconv.Atoi(parentAnswer)
+		if err != nil {
+			fmt.Println("Error: Please enter a valid parent ID!")
+			return
+		}
+		all, err := allTasks()
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		if err := validateParent(all, 0, task.ParentID); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+	if err := createTask(&task); err != nil {
+		fmt.Println("Error:", err)
+		return
 	}
-	tasks = append(tasks, task)
-	currentID++
 	fmt.Println("Task added successfully!")
 }
 
 func listTasks() {
+	names, err := projects.ListProjects()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if len(names) > 1 {
+		everyProject, err := input.Confirm("List the tasks of all projects? (y/N): ")
+		if err != nil {
+			return
+		}
+		if everyProject {
+			listAllTasks()
+			return
+		}
+	}
+
+	tasks, err := allTasks()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
 	if len(tasks) == 0 {
 		fmt.Println("No tasks found!")
 		return
 	}
+
+	expr, err := input.Line("Filter (e.g. tag:infra status:pending -tag:blocked, empty for all): ")
+	if err != nil {
+		return
+	}
+	filter, err := ParseFilter(expr)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	order, err := input.Line("Sort by (id/priority/due/deps) [id]: ")
+	if err != nil {
+		return
+	}
+	archived, err := input.Confirm("Include archived tasks? (y/N): ")
+	if err != nil {
+		return
+	}
+	board, err := input.Confirm("Show as a board by state? (y/N): ")
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	tasks, err = store.List(ListOptions{Filter: filter, Sort: order, Now: now, Archived: archived})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if len(tasks) == 0 {
+		fmt.Println("No tasks match the filter!")
+		return
+	}
+	if board {
+		_, width := terminalSize()
+		fmt.Println()
+		printBoard(os.Stdout, boardColumns(tasks), width, now)
+		return
+	}
+	nodes, err := layoutTasks(tasks, order)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
 	fmt.Println("\nCurrent Tasks:")
 	fmt.Println("-------------")
-	for _, task := range tasks {
-		status := "Pending"
-		if task.Completed {
-			status = "Completed"
-		}
-		fmt.Printf("ID: %d\nTitle: %s\nDescription: %s\nStatus: %s\n\n",
-			task.ID, task.Title, task.Description, status)
+	for _, node := range nodes {
+		printTaskBlock(node, now)
 	}
 }
 
-func completeTask() {
-	var id int
-	fmt.Print("Enter task ID to complete: ")
-	_, err := fmt.Scanln(&id)
+// listAllTasks prints the tasks of every project, project by project
+func listAllTasks() {
+	expr, err := input.Line("Filter (e.g. tag:infra status:pending -tag:blocked, empty for all): ")
+	if err != nil {
+		return
+	}
+	filter, err := ParseFilter(expr)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	order, err := input.Line("Sort by (id/priority/due/deps) [id]: ")
 	if err != nil {
-		fmt.Println("Error: Please enter a valid number!")
 		return
 	}
 
-	for i := range tasks {
-		if tasks[i].ID == id {
-			tasks[i].Completed = true
-			fmt.Println("Task marked as completed!")
-			return
+	now := time.Now()
+	groups, err := listAllProjects(ListOptions{Filter: filter, Sort: order, Now: now})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	for _, group := range groups {
+		title := fmt.Sprintf("Project %s (%d task(s)):", group.Project, len(group.Tasks))
+		fmt.Println("\n" + title)
+		fmt.Println(strings.Repeat("-", len(title)))
+		if len(group.nodes) == 0 {
+			fmt.Println("No tasks.")
+		}
+		for _, node := range group.nodes {
+			printTaskBlock(node, now)
 		}
 	}
-	fmt.Println("Task not found!")
 }
 
-func deleteTask() {
-	var id int
-	fmt.Print("Enter task ID to delete: ")
-	_, err := fmt.Scanln(&id)
+// printTaskBlock prints the details of a task, indented by its depth in
+// the subtask tree
+func printTaskBlock(node treeNode, now time.Time) {
+	task := node.Task
+	indent := strings.Repeat("    ", node.Depth)
+	status := "Pending"
+	if task.ArchivedAt != nil {
+		status = "Completed (archived)"
+	} else if task.Completed {
+		status = "Completed"
+	} else if len(node.Blockers) > 0 {
+		status = colorize(ansiYellow, "Blocked by "+formatIDs(node.Blockers))
+	}
+	due := formatDueDate(task.DueDate)
+	if task.IsOverdue(now) {
+		due = colorize(ansiRed, due+" (OVERDUE)")
+	}
+	description := strings.ReplaceAll(task.Description, "\n", "\n"+indent+"             ")
+	tags := strings.Join(task.Tags, ", ")
+	if tags == "" {
+		tags = "-"
+	}
+
+	fmt.Printf("%sID: %d\n", indent, task.ID)
+	fmt.Printf("%sTitle: %s\n", indent, task.Title)
+	fmt.Printf("%sDescription: %s\n", indent, description)
+	fmt.Printf("%sPriority: %s\n", indent, task.Priority)
+	fmt.Printf("%sDue: %s\n", indent, due)
+	fmt.Printf("%sTags: %s\n", indent, tags)
+	if task.Recurrence != nil {
+		fmt.Printf("%sRepeats: %s\n", indent, task.Recurrence)
+	}
+	if len(task.BlockedBy) > 0 {
+		fmt.Printf("%sBlocked by: %s\n", indent, formatIDs(task.BlockedBy))
+	}
+	fmt.Printf("%sStatus: %s\n", indent, status)
+	fmt.Printf("%sState: %s\n", indent, workflow.StateOf(task))
+	if rollUp := node.rollUp(); rollUp != "" {
+		fmt.Printf("%sProgress: %s\n", indent, rollUp)
+	}
+	if len(task.TimeEntries) > 0 {
+		tracked := formatTracked(task.Tracked(now))
+		if task.Running() {
+			tracked += " (timer running)"
+		}
+		fmt.Printf("%sTracked: %s\n", indent, tracked)
+		for _, day := range task.TrackedByDay(now) {
+			fmt.Printf("%s  %s  %s\n", indent, day.Day.Format("2006-01-02 Mon"), formatTracked(day.Tracked))
+		}
+	}
+	fmt.Println()
+}
+
+func completeTask() {
+	answer, err := input.Line("Enter task ID to complete, or IDs like 3,5,8-12 or a filter: ")
 	if err != nil {
-		fmt.Println("Error: Please enter a valid number!")
+		return
+	}
+	id, ok := singleID(answer)
+	if !ok {
+		completeTasks(answer)
 		return
 	}
 
-	for i := range tasks {
-		if tasks[i].ID == id {
-			tasks = append(tasks[:i], tasks[i+1:]...)
-			fmt.Println("Task deleted successfully!")
+	blockers, err := blockersOf(id)
+	if err == errTaskNotFound {
+		fmt.Println("Task not found!")
+		return
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if len(blockers) > 0 {
+		fmt.Printf("Warning: this task is still blocked by %s\n", formatIDs(blockers))
+		ok, err := input.Confirm("Complete it anyway? (y/N): ")
+		if err != nil || !ok {
 			return
 		}
 	}
-	fmt.Println("Task not found!")
-}
 
-func main() {
-	for {
-		err := clearScreen()
+	open, err := openSubtasks(id)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	cascade := false
+	if len(open) > 0 {
+		cascade, err = input.Confirm(fmt.Sprintf("Also complete %d open subtask(s)? (y/N): ", len(open)))
 		if err != nil {
-			fmt.Println("Warning: Could not clear screen:", err)
+			return
 		}
+	}
 
-		fmt.Println("Task Management System")
-		fmt.Println("1. Add Task")
-		fmt.Println("2. List Tasks")
-		fmt.Println("3. Complete Task")
-		fmt.Println("4. Delete Task")
-		fmt.Println("5. Exit")
+	_, next, err := markCompleted(id, cascade)
+	if err == errTaskNotFound {
+		fmt.Println("Task not found!")
+		return
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Println("Task marked as completed!")
+	if next != nil {
+		fmt.Printf("Next occurrence added as task %d, due %s\n", next.ID, formatDueDate(next.DueDate))
+	}
+}
 
-		var choice int
-		fmt.Print("\nEnter your choice (1-5): ")
-		_, err = fmt.Scanln(&choice)
-		if err != nil {
-			fmt.Println("Error: Please enter a valid number!")
-			continue
-		}
+func changeTaskState() {
+	answer, err := input.Line("Enter task ID to move, or IDs like 3,5,8-12 or a filter: ")
+	if err != nil {
+		return
+	}
+	id, ok := singleID(answer)
+	if !ok {
+		changeTaskStates(answer)
+		return
+	}
 
-		switch choice {
-		case 1:
-			addTask()
-		case 2:
-			listTasks()
-		case 3:
-			completeTask()
-		case 4:
-			deleteTask()
-		case 5:
-			fmt.Println("Goodbye!")
-			return
-		default:
-			fmt.Println("Invalid choice! Please try again.")
-		}
+	task, err := getTask(id)
+	if err == errTaskNotFound {
+		fmt.Println("Task not found!")
+		return
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	state := workflow.StateOf(*task)
+	next := workflow.Next(state)
+	if len(next) == 0 {
+		fmt.Printf("Task is %s, which is final.\n", state)
+		return
+	}
+	to, err := input.Line(fmt.Sprintf("Task is %s. Move to (%s): ", state, strings.Join(next, "/")))
+	if err != nil {
+		return
+	}
+	to = strings.ToLower(to)
 
-		fmt.Print("\nPress Enter to continue...")
-		fmt.Scanln()
+	_, n, err := changeState(id, to)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
 	}
+	fmt.Printf("Task moved to %s!\n", to)
+	if n != nil {
+		fmt.Printf("Next occurrence added as task %d, due %s\n", n.ID, formatDueDate(n.DueDate))
+	}
+}
+
+func deleteTask() {
+	answer, err := input.Line("Enter task ID to delete, or IDs like 3,5,8-12 or a filter: ")
+	if err != nil {
+		return
+	}
+	id, ok := singleID(answer)
+	if !ok {
+		deleteTasks(answer)
+		return
+	}
+
+	subtasks, err := subtasksOf(id)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	cascade := false
+	if len(subtasks) > 0 {
+		cascade, err = input.Confirm(fmt.Sprintf("Task has %d subtask(s). Delete them too? (y/N): ", len(subtasks)))
+		if err != nil {
+			return
+		}
+		if !cascade {
+			fmt.Println("Task not deleted.")
+			return
+		}
+	}
+
+	err = deleteWithSubtasks(id, cascade)
+	if err == errTaskNotFound {
+		fmt.Println("Task not found!")
+		return
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Println("Task moved to the trash!")
+}
+
+func editTask() {
+	answer, err := input.Line("Enter task ID to edit, or IDs like 3,5,8-12 or a filter: ")
+	if err != nil {
+		return
+	}
+	id, ok := singleID(answer)
+	if !ok {
+		editTasks(answer)
+		return
+	}
+
+	old, err := getTask(id)
+	if err == errTaskNotFound {
+		fmt.Println("Task not found!")
+		return
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	fmt.Println("Press Enter to keep a value, or enter - to clear an optional one.")
+	task := *snapshot(old)
+
+	title, err := input.Line(fmt.Sprintf("Title [%s]: ", old.Title))
+	if err != nil {
+		return
+	}
+	if title != "" {
+		task.Title = title
+	}
+
+	fmt.Printf("Current description:\n%s\n", old.Description)
+	description, err := input.Multiline("New description (finish with an empty line):\n")
+	if err != nil {
+		return
+	}
+	switch description {
+	case "":
+	case "-":
+		task.Description = ""
+	default:
+		task.Description = description
+	}
+
+	answer, err = input.Line(fmt.Sprintf("Priority [%s]: ", old.Priority))
+	if err != nil {
+		return
+	}
+	if answer != "" {
+		if task.Priority, err = ParsePriority(answer); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+
+	answer, err = input.Line(fmt.Sprintf("Due date [%s]: ", formatDueDate(old.DueDate)))
+	if err != nil {
+		return
+	}
+	switch answer {
+	case "":
+	case "-":
+		task.DueDate = nil
+	default:
+		due, ok := confirmDueDate(answer)
+		if !ok {
+			return
+		}
+		task.DueDate = &due
+	}
+
+	answer, err = input.Line(fmt.Sprintf("Tags [%s]: ", strings.Join(old.Tags, ", ")))
+	if err != nil {
+		return
+	}
+	switch answer {
+	case "":
+	case "-":
+		task.Tags = nil
+	default:
+		task.Tags = parseTagList(answer)
+	}
+
+	answer, err = input.Line(fmt.Sprintf("Parent task ID [%s]: ", formatParent(old.ParentID)))
+	if err != nil {
+		return
+	}
+	switch answer {
+	case "":
+	case "-":
+		task.ParentID = 0
+	default:
+		if task.ParentID, err = strconv.Atoi(answer); err != nil {
+			fmt.Println("Error: Please enter a valid parent ID!")
+			return
+		}
+	}
+
+	answer, err = input.Line(fmt.Sprintf("Repeat [%s]: ", formatRecurrence(old.Recurrence)))
+	if err != nil {
+		return
+	}
+	switch answer {
+	case "":
+	case "-":
+		task.Recurrence = nil
+	default:
+		if task.Recurrence, err = ParseRecurrence(answer); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+
+	if task.Title == "" {
+		fmt.Println("Error: Title cannot be empty!")
+		return
+	}
+	if task.ParentID != old.ParentID {
+		all, err := allTasks()
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		if err := validateParent(all, task.ID, task.ParentID); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+
+	changes := diffTasks(*old, task)
+	if len(changes) == 0 {
+		fmt.Println("No changes made.")
+		return
+	}
+	if err := updateTask(&task); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Println("Task updated:")
+	for _, change := range changes {
+		fmt.Println("  " + change.String())
+	}
+}
+
+func tagTask() {
+	answer, err := input.Line("Enter task ID to tag, or IDs like 3,5,8-12 or a filter: ")
+	if err != nil {
+		return
+	}
+	id, ok := singleID(answer)
+	if !ok {
+		tagTasks(answer)
+		return
+	}
+
+	task, err := getTask(id)
+	if err == errTaskNotFound {
+		fmt.Println("Task not found!")
+		return
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	fmt.Printf("Current tags: %s\n", strings.Join(task.Tags, ", "))
+	add, err := input.Line("Tags to add (comma separated): ")
+	if err != nil {
+		return
+	}
+	remove, err := input.Line("Tags to remove (comma separated): ")
+	if err != nil {
+		return
+	}
+
+	task.AddTags(parseTagList(add)...)
+	task.RemoveTags(parseTagList(remove)...)
+	if err := updateTask(task); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Println("Tags updated!")
+}
+
+// selectForMenu selects the tasks of expr for a menu entry, saying why
+// when there are none
+func selectForMenu(expr string) ([]Task, bool) {
+	tasks, err := selectTasks(expr)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return nil, false
+	}
+	if len(tasks) == 0 {
+		fmt.Println("No tasks match!")
+		return nil, false
+	}
+	return tasks, true
+}
+
+// completeTasks completes the tasks selected by expr after showing them
+func completeTasks(expr string) {
+	tasks, ok := selectForMenu(expr)
+	if !ok {
+		return
+	}
+	blocked, err := openBlockersOutside(tasks)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	for _, task := range tasks {
+		if blockers := blocked[task.ID]; len(blockers) > 0 && !task.Completed {
+			fmt.Printf("Warning: task %d is still blocked by %s\n", task.ID, formatIDs(blockers))
+		}
+	}
+	subtasks, err := unselectedSubtasks(tasks)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	open := 0
+	for _, sub := range subtasks {
+		if !sub.Completed {
+			open++
+		}
+	}
+	if ok, err := askSelection("Complete", tasks); err != nil || !ok {
+		fmt.Println("No tasks completed.")
+		return
+	}
+	cascade := false
+	if open > 0 {
+		cascade, err = input.Confirm(fmt.Sprintf("Also complete %d open subtask(s)? (y/N): ", open))
+		if err != nil {
+			return
+		}
+	}
+
+	completed, next, err := completeSelected(tasks, cascade)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("%d task(s) marked as completed!\n", len(completed))
+	for _, n := range next {
+		fmt.Printf("Next occurrence added as task %d, due %s\n", n.ID, formatDueDate(n.DueDate))
+	}
+}
+
+// changeTaskStates moves the tasks selected by expr to another workflow
+// state after showing them
+func changeTaskStates(expr string) {
+	tasks, ok := selectForMenu(expr)
+	if !ok {
+		return
+	}
+	to, err := input.Line(fmt.Sprintf("Move to (%s): ", strings.Join(workflow.States, "/")))
+	if err != nil {
+		return
+	}
+	to = strings.ToLower(to)
+	if !workflow.Has(to) {
+		fmt.Printf("Error: Unknown state %q!\n", to)
+		return
+	}
+	if ok, err := askSelection("Move", tasks); err != nil || !ok {
+		fmt.Println("No tasks moved.")
+		return
+	}
+
+	moved, next, err := changeSelectedState(tasks, to)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("%d task(s) moved to %s!\n", len(moved), to)
+	for _, n := range next {
+		fmt.Printf("Next occurrence added as task %d, due %s\n", n.ID, formatDueDate(n.DueDate))
+	}
+}
+
+// deleteTasks moves the tasks selected by expr to the trash after
+// showing them
+func deleteTasks(expr string) {
+	tasks, ok := selectForMenu(expr)
+	if !ok {
+		return
+	}
+	subtasks, err := unselectedSubtasks(tasks)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if ok, err := askSelection("Delete", tasks); err != nil || !ok {
+		fmt.Println("No tasks deleted.")
+		return
+	}
+	cascade := false
+	if len(subtasks) > 0 {
+		cascade, err = input.Confirm(fmt.Sprintf("The tasks have %d other subtask(s). Delete them too? (y/N): ", len(subtasks)))
+		if err != nil {
+			return
+		}
+		if !cascade {
+			fmt.Println("No tasks deleted.")
+			return
+		}
+	}
+
+	if err := deleteSelected(tasks, cascade); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("%d task(s) moved to the trash!\n", len(tasks))
+}
+
+// editTasks changes the priority, due date, parent or recurrence of the
+// tasks selected by expr
+func editTasks(expr string) {
+	tasks, ok := selectForMenu(expr)
+	if !ok {
+		return
+	}
+
+	fmt.Println("Press Enter to keep each task's value, or enter - to clear it.")
+	var edits []func(task *Task) error
+	answer, err := input.Line("Priority: ")
+	if err != nil {
+		return
+	}
+	if answer != "" {
+		priority, err := ParsePriority(answer)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		edits = append(edits, func(task *Task) error {
+			task.Priority = priority
+			return nil
+		})
+	}
+
+	answer, err = input.Line("Due date: ")
+	if err != nil {
+		return
+	}
+	switch answer {
+	case "":
+	case "-":
+		edits = append(edits, func(task *Task) error {
+			task.DueDate = nil
+			return nil
+		})
+	default:
+		due, ok := confirmDueDate(answer)
+		if !ok {
+			return
+		}
+		edits = append(edits, func(task *Task) error {
+			dueDate := due
+			task.DueDate = &dueDate
+			return nil
+		})
+	}
+
+	answer, err = input.Line("Parent task ID: ")
+	if err != nil {
+		return
+	}
+	if answer != "" {
+		parentID := 0
+		if answer != "-" {
+			if parentID, err = strconv.Atoi(answer); err != nil {
+				fmt.Println("Error: Please enter a valid parent ID!")
+				return
+			}
+		}
+		edits = append(edits, func(task *Task) error {
+			all, err := allTasks()
+			if err != nil {
+				return err
+			}
+			if err := validateParent(all, task.ID, parentID); err != nil {
+				return err
+			}
+			task.ParentID = parentID
+			return nil
+		})
+	}
+
+	answer, err = input.Line("Repeat: ")
+	if err != nil {
+		return
+	}
+	switch answer {
+	case "":
+	case "-":
+		edits = append(edits, func(task *Task) error {
+			task.Recurrence = nil
+			return nil
+		})
+	default:
+		if _, err := ParseRecurrence(answer); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		rule := answer
+		edits = append(edits, func(task *Task) error {
+			task.Recurrence, _ = ParseRecurrence(rule)
+			return nil
+		})
+	}
+
+	if len(edits) == 0 {
+		fmt.Println("No changes made.")
+		return
+	}
+	if ok, err := askSelection("Edit", tasks); err != nil || !ok {
+		fmt.Println("No changes made.")
+		return
+	}
+	edited, err := editSelected(tasks, func(task *Task) error {
+		for _, edit := range edits {
+			if err := edit(task); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	for _, e := range edited {
+		printEdited(e)
+	}
+}
+
+// tagTasks adds and removes tags on the tasks selected by expr
+func tagTasks(expr string) {
+	tasks, ok := selectForMenu(expr)
+	if !ok {
+		return
+	}
+	add, err := input.Line("Tags to add (comma separated): ")
+	if err != nil {
+		return
+	}
+	remove, err := input.Line("Tags to remove (comma separated): ")
+	if err != nil {
+		return
+	}
+	if add == "" && remove == "" {
+		fmt.Println("No changes made.")
+		return
+	}
+	if ok, err := askSelection("Tag", tasks); err != nil || !ok {
+		fmt.Println("No changes made.")
+		return
+	}
+
+	if _, err := tagSelected(tasks, parseTagList(add), parseTagList(remove)); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Tags of %d task(s) updated!\n", len(tasks))
+}
+
+func setTaskBlockers() {
+	id, err := input.Int("Enter task ID: ")
+	if err == errInvalidNumber {
+		fmt.Println("Error: Please enter a valid number!")
+		return
+	}
+	if err != nil {
+		return
+	}
+	task, err := getTask(id)
+	if err == errTaskNotFound {
+		fmt.Println("Task not found!")
+		return
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	fmt.Printf("Currently blocked by: %s\n", formatIDs(task.BlockedBy))
+	answer, err := input.Line("Blocked by (comma separated task IDs, empty for none): ")
+	if err != nil {
+		return
+	}
+	blockers, err := parseIDList(answer)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if task, err = setBlockers(id, blockers); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Task %d is blocked by: %s\n", task.ID, formatIDs(task.BlockedBy))
+}
+
+func searchTasks() {
+	query, err := input.Line("Search for: ")
+	if err != nil {
+		return
+	}
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		fmt.Println("Error: Please enter at least one word to search for!")
+		return
+	}
+
+	tasks, err := allTasks()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	results := Search(tasks, query)
+	if len(results) == 0 {
+		fmt.Println("No matching tasks found!")
+		return
+	}
+
+	fmt.Printf("\nFound %d task(s):\n", len(results))
+	fmt.Println("-------------")
+	for _, result := range results {
+		task := result.Task
+		status := "Pending"
+		if task.ArchivedAt != nil {
+			status = "Completed (archived)"
+		} else if task.Completed {
+			status = "Completed"
+		}
+		description := strings.ReplaceAll(highlightTerms(task.Description, terms), "\n", "\n             ")
+		fmt.Printf("ID: %d\nTitle: %s\nDescription: %s\nStatus: %s\n\n",
+			task.ID, highlightTerms(task.Title, terms), description, status)
+	}
+}
+
+func showTaskHistory() {
+	id, err := input.Int("Enter task ID: ")
+	if err == errInvalidNumber {
+		fmt.Println("Error: Please enter a valid number!")
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	events, err := taskHistory(id)
+	if err == errTaskNotFound {
+		fmt.Println("Task not found!")
+		return
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	printTimeline(os.Stdout, id, events)
+}
+
+func exportToFile() {
+	path, err := input.Line("Export to file (.csv, .md, .ics or .txt): ")
+	if err != nil {
+		return
+	}
+	format := formatFromPath(path)
+	if format == "" {
+		fmt.Println("Error: The file name must end in .csv, .md, .ics or .txt!")
+		return
+	}
+
+	tasks, err := allTasks()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer f.Close()
+	if err := exportTasks(f, tasks, format); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Exported %d task(s) to %s\n", len(tasks), path)
+}
+
+func importFromFile() {
+	path, err := input.Line("Import from file (.csv, .md or .txt): ")
+	if err != nil {
+		return
+	}
+	format := formatFromPath(path)
+	if format != formatCSV && format != formatMarkdown && format != formatTodoTxt {
+		fmt.Println("Error: The file name must end in .csv, .md or .txt!")
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer f.Close()
+
+	items, problems, err := parseImport(f, format)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	created, linkProblems, err := importTasks(items)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	problems = append(problems, linkProblems...)
+	fmt.Printf("Imported %d task(s) from %s\n", len(created), path)
+	for _, problem := range problems {
+		fmt.Println("  Skipped", problem)
+	}
+}
+
+func undoLast() {
+	cmd, err := history.UndoLast()
+	if err == errNothingToUndo {
+		fmt.Println("Nothing to undo!")
+		return
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Println("Undone:", cmd.describe())
+}
+
+func redoLast() {
+	cmd, err := history.RedoLast()
+	if err == errNothingToRedo {
+		fmt.Println("Nothing to redo!")
+		return
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Println("Redone:", cmd.describe())
+}
+
+func snoozeReminder() {
+	id, err := input.Int("Enter task ID to snooze: ")
+	if err == errInvalidNumber {
+		fmt.Println("Error: Please enter a valid number!")
+		return
+	}
+	if err != nil {
+		return
+	}
+	text, err := input.Line(fmt.Sprintf("Snooze for (e.g. 30m, 2h, 1d) [%s]: ", formatDuration(defaultSnooze)))
+	if err != nil {
+		return
+	}
+	d := defaultSnooze
+	if text != "" {
+		if d, err = parseDuration(text); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+
+	until, err := snoozeTask(id, d)
+	if err == errTaskNotFound {
+		fmt.Println("Task not found!")
+		return
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Reminders for task %d snoozed until %s\n", id, until.Format("2006-01-02 15:04"))
+}
+
+func startTaskTimer() {
+	id, err := input.Int("Enter task ID to start the timer for: ")
+	if err == errInvalidNumber {
+		fmt.Println("Error: Please enter a valid number!")
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	task, err := startTimer(id)
+	if err == errTaskNotFound {
+		fmt.Println("Task not found!")
+		return
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Timer started for task %d: %s\n", task.ID, task.Title)
+}
+
+func stopTaskTimer() {
+	task, err := stopTimer(0)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	entry := task.TimeEntries[len(task.TimeEntries)-1]
+	fmt.Printf("Timer stopped for task %d after %s (%s in total)\n", task.ID,
+		formatTracked(entry.End.Sub(entry.Start)), formatTracked(task.Tracked(time.Now())))
+}
+
+func showTimesheet() {
+	now := time.Now()
+	from, to := thisWeek(now), startOfDay(now)
+	fromAnswer, err := input.Line(fmt.Sprintf("From (YYYY-MM-DD) [%s]: ", from.Format("2006-01-02")))
+	if err != nil {
+		return
+	}
+	toAnswer, err := input.Line(fmt.Sprintf("To (YYYY-MM-DD) [%s]: ", to.Format("2006-01-02")))
+	if err != nil {
+		return
+	}
+	if fromAnswer != "" {
+		if from, err = parseDay(fromAnswer); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+	if toAnswer != "" {
+		if to, err = parseDay(toAnswer); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+
+	tasks, err := trackedTasks()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Println()
+	printTimesheet(os.Stdout, buildTimesheet(tasks, from, to, now))
+}
+
+func showReport() {
+	now := time.Now()
+	from, to := startOfDay(now).AddDate(0, 0, 1-reportDays), startOfDay(now)
+	fromAnswer, err := input.Line(fmt.Sprintf("From (YYYY-MM-DD) [%s]: ", from.Format("2006-01-02")))
+	if err != nil {
+		return
+	}
+	toAnswer, err := input.Line(fmt.Sprintf("To (YYYY-MM-DD) [%s]: ", to.Format("2006-01-02")))
+	if err != nil {
+		return
+	}
+	if fromAnswer != "" {
+		if from, err = parseDay(fromAnswer); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+	if toAnswer != "" {
+		if to, err = parseDay(toAnswer); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+
+	tasks, err := store.List(ListOptions{Archived: true, Trashed: true})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Println()
+	printReport(os.Stdout, buildReport(tasks, from, to, now))
+}
+
+func archiveTasks() {
+	answer, err := input.Line("Enter task ID to archive (empty for all completed tasks): ")
+	if err != nil {
+		return
+	}
+
+	var archived []Task
+	if answer == "" {
+		archived, err = archiveCompleted()
+	} else {
+		id, convErr := strconv.Atoi(answer)
+		if convErr != nil {
+			fmt.Println("Error: Please enter a valid number!")
+			return
+		}
+		archived, err = archiveTask(id)
+	}
+	if err == errTaskNotFound {
+		fmt.Println("Task not found!")
+		return
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Archived %d task(s).\n", len(archived))
+}
+
+func manageTrash() {
+	trashed, err := trashedTasks()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Println("\nTrash:")
+	printTrash(os.Stdout, trashed)
+	if len(trashed) == 0 {
+		return
+	}
+
+	action, err := input.Line("\nRestore a task or purge old ones (r/p, empty to go back): ")
+	if err != nil {
+		return
+	}
+	switch strings.ToLower(action) {
+	case "":
+		return
+	case "r":
+		id, err := input.Int("Enter task ID to restore: ")
+		if err == errInvalidNumber {
+			fmt.Println("Error: Please enter a valid number!")
+			return
+		}
+		if err != nil {
+			return
+		}
+		restored, err := restoreTask(id)
+		if err == errTaskNotFound {
+			fmt.Println("Task not found!")
+			return
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		fmt.Printf("Restored %d task(s).\n", len(restored))
+	case "p":
+		answer, err := input.Line(fmt.Sprintf("Purge tasks deleted at least how long ago? [%s]: ", formatDuration(defaultPurgeAge)))
+		if err != nil {
+			return
+		}
+		age := defaultPurgeAge
+		if answer != "" {
+			if age, err = parseDuration(answer); err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+		}
+		purged, err := purgeTrash(age, time.Now())
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		fmt.Printf("Purged %d task(s) from the trash.\n", len(purged))
+	default:
+		fmt.Println("Invalid choice!")
+	}
+}
+
+func moveTask() {
+	id, err := input.Int("Enter task ID to move: ")
+	if err == errInvalidNumber {
+		fmt.Println("Error: Please enter a valid number!")
+		return
+	}
+	if err != nil {
+		return
+	}
+	target, err := input.Line("Move to project: ")
+	if err != nil || target == "" {
+		return
+	}
+
+	moved, err := moveTasks([]int{id}, target)
+	if err == errTaskNotFound {
+		fmt.Println("Task not found!")
+		return
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	for _, m := range moved {
+		fmt.Printf("Moved task %d to project %s as task %d\n", m.OldID, target, m.Task.ID)
+	}
+}
+
+func manageProjects() {
+	summaries, err := projectSummaries()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Println("\nProjects (* is the current one):")
+	printProjects(os.Stdout, summaries)
+
+	action, err := input.Line("\nSwitch, create, rename or delete a project (s/c/r/d, empty to go back): ")
+	if err != nil {
+		return
+	}
+	switch strings.ToLower(action) {
+	case "":
+		return
+	case "s":
+		name, err := input.Line("Switch to project: ")
+		if err != nil || name == "" {
+			return
+		}
+		if err := switchProject(name); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		fmt.Println("Switched to project", name)
+	case "c":
+		name, err := input.Line("New project name: ")
+		if err != nil || name == "" {
+			return
+		}
+		if err := createProject(name); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		fmt.Println("Project created successfully!")
+	case "r":
+		oldName, err := input.Line("Project to rename: ")
+		if err != nil || oldName == "" {
+			return
+		}
+		newName, err := input.Line("New name: ")
+		if err != nil || newName == "" {
+			return
+		}
+		if err := renameProject(oldName, newName); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		fmt.Println("Project renamed successfully!")
+	case "d":
+		name, err := input.Line("Project to delete: ")
+		if err != nil || name == "" {
+			return
+		}
+		force := false
+		for _, p := range summaries {
+			if p.Name == name && p.Tasks > 0 && name != currentProject {
+				force, err = input.Confirm(fmt.Sprintf("Project %s has %d task(s). Delete them too? (y/N): ", name, p.Tasks))
+				if err != nil || !force {
+					fmt.Println("Deletion cancelled.")
+					return
+				}
+			}
+		}
+		if err := deleteProject(name, force); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		fmt.Println("Project deleted successfully!")
+	default:
+		fmt.Println("Invalid choice!")
+	}
+}
+
+// openStorage opens the SQLite database at dbPath, or the JSON files
+// next to dataFile when dbPath is empty, and makes project, or the
+// project last switched to when it is empty, the current one. The undo
+// history and the reminder state are kept next to either one.
+func openStorage(dbPath, project string) error {
+	if dbPath == "" {
+		storagePath = dataFile
+		projects = fileProjects{path: dataFile}
+	} else {
+		storagePath = dbPath
+		db, err := NewDatabase(dbPath)
+		if err != nil {
+			return err
+		}
+		if err := db.InitSchema(); err != nil {
+			db.Close()
+			return fmt.Errorf("could not initialise database: %v", err)
+		}
+		projects = db
+	}
+
+	if project == "" {
+		project = savedProject()
+	}
+	if err := useProject(project); err != nil {
+		return fmt.Errorf("could not load tasks: %v", err)
+	}
+	var err error
+	if workflow, err = loadWorkflow(storagePath + ".workflow"); err != nil {
+		return fmt.Errorf("could not load workflow: %v", err)
+	}
+	history, err = loadHistory(storagePath + ".history")
+	if err != nil {
+		return fmt.Errorf("could not load undo history: %v", err)
+	}
+	return nil
+}
+
+// menuItem is one numbered entry of the interactive menu. Unless it is
+// untracked, whatever an entry changes is recorded as one undoable
+// command.
+type menuItem struct {
+	label     string
+	action    func()
+	untracked bool
+}
+
+// menuItems lists the menu entries in display order; Exit is always
+// offered after the last one
+var menuItems = []menuItem{
+	{"Add Task", addTask, false},
+	{"List Tasks", listTasks, false},
+	{"Complete Task", completeTask, false},
+	{"Change State", changeTaskState, false},
+	{"Delete Task", deleteTask, false},
+	{"Edit Task", editTask, false},
+	{"Tag Task", tagTask, false},
+	{"Set Blockers", setTaskBlockers, false},
+	{"Move Task", moveTask, false},
+	{"Search Tasks", searchTasks, false},
+	{"Task History", showTaskHistory, false},
+	{"Export Tasks", exportToFile, false},
+	{"Import Tasks", importFromFile, false},
+	{"Archive Tasks", archiveTasks, false},
+	{"Trash", manageTrash, false},
+	{"Snooze Reminder", snoozeReminder, true},
+	{"Start Timer", startTaskTimer, false},
+	{"Stop Timer", stopTaskTimer, false},
+	{"Timesheet", showTimesheet, false},
+	{"Report", showReport, false},
+	{"Projects", manageProjects, true},
+	{"Undo", undoLast, true},
+	{"Redo", redoLast, true},
+}
+
+// runMenu runs the interactive numbered menu until the user exits
+func runMenu() {
+	exitChoice := len(menuItems) + 1
+	for {
+		err := clearScreen()
+		if err != nil {
+			fmt.Println("Warning: Could not clear screen:", err)
+		}
+
+		fmt.Printf("Task Management System - project %s\n", currentProject)
+		for i, item := range menuItems {
+			fmt.Printf("%d. %s\n", i+1, item.label)
+		}
+		fmt.Printf("%d. Exit\n", exitChoice)
+
+		choice, err := input.Int(fmt.Sprintf("\nEnter your choice (1-%d): ", exitChoice))
+		if err == errInvalidNumber {
+			fmt.Println("Error: Please enter a valid number!")
+			continue
+		}
+		if err != nil {
+			fmt.Println("\nGoodbye!")
+			return
+		}
+
+		switch {
+		case choice == exitChoice:
+			fmt.Println("Goodbye!")
+			return
+		case choice >= 1 && choice <= len(menuItems):
+			runMenuItem(menuItems[choice-1])
+		default:
+			fmt.Println("Invalid choice! Please try again.")
+		}
+
+		if _, err := input.Line("\nPress Enter to continue..."); err != nil {
+			fmt.Println("\nGoodbye!")
+			return
+		}
+	}
+}
+
+// runMenuItem runs a menu entry, recording its changes for undo. The
+// reminder scheduler waits while an entry runs.
+func runMenuItem(item menuItem) {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	if item.untracked {
+		item.action()
+		return
+	}
+	history.Begin(item.label)
+	item.action()
+	if err := history.End(); err != nil {
+		fmt.Println("Warning:", err)
+	}
+}
+
+func main() {
+	dbPath := flag.String("db", "", "SQLite database to store tasks in instead of the JSON file")
+	flag.StringVar(&dataFile, "file", dataFile, "JSON file to load and save tasks")
+	flag.StringVar(&remindLeads, "remind", remindLeads, "comma-separated times before the due date to send reminders, e.g. 1d,2h,0")
+	flag.StringVar(&notifyCommand, "notify", "", "shell command that delivers reminders instead of the terminal")
+	project := flag.String("project", "", "project to work on instead of the current one")
+	flag.Usage = usage
+	flag.Parse()
+
+	if err := openStorage(*dbPath, *project); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitError)
+	}
+
+	code := runCommand(flag.Args())
+	closeProjects()
+	os.Exit(code)
 }
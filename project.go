@@ -0,0 +1,435 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// defaultProject holds the tasks of users who never create a project
+const defaultProject = "default"
+
+// errProjectNotFound is returned by Projects when no project has the
+// requested name
+var errProjectNotFound = errors.New("project not found")
+
+// projectNotFoundError reports the name of a project that does not exist
+type projectNotFoundError struct {
+	name string
+}
+
+func (e projectNotFoundError) Error() string {
+	return fmt.Sprintf("project %q does not exist", e.name)
+}
+
+// Projects manages the named task lists of a storage backend. Each
+// project has its own tasks and its own sequence of IDs.
+type Projects interface {
+	ListProjects() ([]string, error)
+	OpenProject(name string) (TaskStore, error)
+	CreateProject(name string) error
+	RenameProject(oldName, newName string) error
+	DeleteProject(name string) error
+	Close() error
+}
+
+// projects are the projects of the storage opened by openStorage
+var projects Projects
+
+// currentProject is the project commands work on; store holds its tasks
+var currentProject = defaultProject
+
+// openStores holds the store of every project used so far
+var openStores = map[string]TaskStore{}
+
+var projectNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,39}$`)
+
+// validateProjectName checks that name is short and made of lowercase
+// letters, digits, '-' and '_', so it is safe in file names
+func validateProjectName(name string) error {
+	if !projectNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid project name %q (use up to 40 lowercase letters, digits, - and _)", name)
+	}
+	return nil
+}
+
+// projectStore returns the store of project name, opening it once
+func projectStore(name string) (TaskStore, error) {
+	if s, ok := openStores[name]; ok {
+		return s, nil
+	}
+	s, err := projects.OpenProject(name)
+	if err == errProjectNotFound {
+		return nil, projectNotFoundError{name}
+	}
+	if err != nil {
+		return nil, err
+	}
+	openStores[name] = s
+	return s, nil
+}
+
+// closeProjects closes every open store and the projects themselves
+func closeProjects() {
+	for name, s := range openStores {
+		s.Close()
+		delete(openStores, name)
+	}
+	if projects != nil {
+		projects.Close()
+	}
+}
+
+// projectSidecar returns the path of a file kept for project name next
+// to the tasks, such as its reminder state
+func projectSidecar(name, suffix string) string {
+	if name == defaultProject {
+		return storagePath + suffix
+	}
+	return storagePath + "." + name + suffix
+}
+
+// useProject makes name the project commands work on
+func useProject(name string) error {
+	s, err := projectStore(name)
+	if err != nil {
+		return err
+	}
+	store = s
+	currentProject = name
+	return nil
+}
+
+// savedProject returns the project chosen with "project switch", or the
+// default project
+func savedProject() string {
+	data, err := ioutil.ReadFile(storagePath + ".project")
+	if err != nil {
+		return defaultProject
+	}
+	if name := strings.TrimSpace(string(data)); name != "" {
+		return name
+	}
+	return defaultProject
+}
+
+// switchProject makes name the current project, also for later runs
+func switchProject(name string) error {
+	if err := useProject(name); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(storagePath+".project", []byte(name+"\n"), 0644); err != nil {
+		return fmt.Errorf("error saving current project: %v", err)
+	}
+	return nil
+}
+
+// projectExists reports whether a project called name exists
+func projectExists(name string) (bool, error) {
+	names, err := projects.ListProjects()
+	if err != nil {
+		return false, err
+	}
+	for _, existing := range names {
+		if existing == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// createProject adds an empty project
+func createProject(name string) error {
+	if err := validateProjectName(name); err != nil {
+		return err
+	}
+	exists, err := projectExists(name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("project %q already exists", name)
+	}
+	return projects.CreateProject(name)
+}
+
+// renameProject renames a project along with its reminder state and its
+// entries in the undo history
+func renameProject(oldName, newName string) error {
+	if oldName == defaultProject {
+		return fmt.Errorf("the default project cannot be renamed")
+	}
+	if err := validateProjectName(newName); err != nil {
+		return err
+	}
+	exists, err := projectExists(newName)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("project %q already exists", newName)
+	}
+
+	if s, ok := openStores[oldName]; ok {
+		s.Close()
+		delete(openStores, oldName)
+	}
+	if err := projects.RenameProject(oldName, newName); err == errProjectNotFound {
+		return projectNotFoundError{oldName}
+	} else if err != nil {
+		return err
+	}
+	// Without its reminder state the project's reminders would fire again
+	if err := os.Rename(projectSidecar(oldName, ".reminders"), projectSidecar(newName, ".reminders")); err != nil && !os.IsNotExist(err) {
+		projects.RenameProject(newName, oldName)
+		return fmt.Errorf("error renaming the reminders of project %s: %v", oldName, err)
+	}
+	if err := history.renameProject(oldName, newName); err != nil {
+		return err
+	}
+	if currentProject == oldName || savedProject() == oldName {
+		return switchProject(newName)
+	}
+	return nil
+}
+
+// deleteProject removes a project. A project that still has tasks is
+// only deleted when force is set.
+func deleteProject(name string, force bool) error {
+	if name == defaultProject {
+		return fmt.Errorf("the default project cannot be deleted")
+	}
+	if name == currentProject {
+		return fmt.Errorf("project %q is the current project; switch to another one first", name)
+	}
+	s, err := projectStore(name)
+	if err != nil {
+		return err
+	}
+	tasks, err := s.List(ListOptions{})
+	if err != nil {
+		return err
+	}
+	if len(tasks) > 0 && !force {
+		return fmt.Errorf("project %q has %d task(s); delete it with its tasks or move them first", name, len(tasks))
+	}
+
+	s.Close()
+	delete(openStores, name)
+	if err := projects.DeleteProject(name); err != nil {
+		return err
+	}
+	os.Remove(projectSidecar(name, ".reminders"))
+	if savedProject() == name {
+		return switchProject(defaultProject)
+	}
+	return nil
+}
+
+// projectSummary describes a project for listings
+type projectSummary struct {
+	Name    string `json:"name"`
+	Tasks   int    `json:"tasks"`
+	Open    int    `json:"open"`
+	Current bool   `json:"current"`
+}
+
+// projectSummaries counts the tasks of every project
+func projectSummaries() ([]projectSummary, error) {
+	names, err := projects.ListProjects()
+	if err != nil {
+		return nil, err
+	}
+	var summaries []projectSummary
+	for _, name := range names {
+		s, err := projectStore(name)
+		if err != nil {
+			return nil, err
+		}
+		tasks, err := s.List(ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		summary := projectSummary{Name: name, Tasks: len(tasks), Current: name == currentProject}
+		for _, task := range tasks {
+			if !task.Completed {
+				summary.Open++
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// projectTasks are the tasks of one project in the all-projects view,
+// with nodes arranged for display
+type projectTasks struct {
+	Project string     `json:"project"`
+	Tasks   []Task     `json:"tasks"`
+	nodes   []treeNode `json:"-"`
+}
+
+// listAllProjects returns the tasks of every project selected by opts
+func listAllProjects(opts ListOptions) ([]projectTasks, error) {
+	names, err := projects.ListProjects()
+	if err != nil {
+		return nil, err
+	}
+	var all []projectTasks
+	for _, name := range names {
+		s, err := projectStore(name)
+		if err != nil {
+			return nil, err
+		}
+		tasks, err := s.List(opts)
+		if err != nil {
+			return nil, err
+		}
+		full, err := s.List(ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if tasks == nil {
+			tasks = []Task{}
+		}
+		all = append(all, projectTasks{Project: name, Tasks: tasks, nodes: arrangeTasks(tasks, full, opts.Sort)})
+	}
+	return all, nil
+}
+
+// movedTask is a task moved to another project and the ID it had before
+type movedTask struct {
+	OldID int  `json:"old_id"`
+	Task  Task `json:"task"`
+}
+
+// moveTasks moves the tasks ids, each with its subtasks, from the current
+// project to target, where they get new IDs. Links between moved tasks
+// are kept; parent and blocker links to tasks left behind are dropped,
+// as IDs only mean something within a project. If the move fails, both
+// projects keep the tasks they had.
+func moveTasks(ids []int, target string) ([]movedTask, error) {
+	if target == currentProject {
+		return nil, fmt.Errorf("the tasks are already in project %q", target)
+	}
+	if _, err := projectStore(target); err != nil {
+		return nil, err
+	}
+	all, err := allTasks()
+	if err != nil {
+		return nil, err
+	}
+	index := taskIndex(all)
+
+	// Collect the tasks with their subtasks, parents before children
+	moving := map[int]bool{}
+	var order []Task
+	for _, id := range ids {
+		task, ok := index[id]
+		if !ok {
+			return nil, errTaskNotFound
+		}
+		for _, t := range append([]Task{task}, descendants(all, id)...) {
+			if !moving[t.ID] {
+				moving[t.ID] = true
+				order = append(order, t)
+			}
+		}
+	}
+
+	// The copies are made first, so that a failure leaves the tasks in
+	// the current project
+	mark := history.mark()
+	var moved []Task
+	err = atomicallyIn(target, func() error {
+		newIDs := map[int]int{}
+		moved = nil
+		for _, task := range order {
+			copied := *snapshot(&task)
+			copied.ID = 0
+			if newParent, ok := newIDs[task.ParentID]; ok {
+				copied.ParentID = newParent
+			} else {
+				copied.ParentID = 0
+			}
+			if err := createTaskIn(target, &copied); err != nil {
+				return err
+			}
+			newIDs[task.ID] = copied.ID
+			moved = append(moved, copied)
+		}
+
+		// Blockers can point either way, so they are set once all tasks
+		// have their new IDs
+		for i := range moved {
+			var blockers []int
+			for _, blocker := range order[i].BlockedBy {
+				if newID, ok := newIDs[blocker]; ok {
+					blockers = append(blockers, newID)
+				}
+			}
+			if len(blockers) == 0 && len(moved[i].BlockedBy) == 0 {
+				continue
+			}
+			moved[i].BlockedBy = normalizeIDs(blockers)
+			if err := updateTaskIn(target, &moved[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = atomically(func() error {
+		// Tasks left behind stop waiting for the ones that moved away
+		for _, task := range all {
+			if moving[task.ID] || len(task.BlockedBy) == 0 {
+				continue
+			}
+			var kept []int
+			for _, blocker := range task.BlockedBy {
+				if !moving[blocker] {
+					kept = append(kept, blocker)
+				}
+			}
+			if len(kept) != len(task.BlockedBy) {
+				task.BlockedBy = kept
+				if err := updateTask(&task); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Children first, so no task is left with a missing parent
+		for i := len(order) - 1; i >= 0; i-- {
+			if err := removeTask(order[i].ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// Take the copies out of target again, so that the tasks are
+		// only where they were
+		atomicallyIn(target, func() error {
+			for i := len(moved) - 1; i >= 0; i-- {
+				if err := removeTaskIn(target, moved[i].ID); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		history.discard(mark)
+		return nil, err
+	}
+	result := make([]movedTask, len(moved))
+	for i := range moved {
+		result[i] = movedTask{OldID: order[i].ID, Task: moved[i]}
+	}
+	return result, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestFileProjects runs the project tests against JSON files
+func TestFileProjects(t *testing.T) {
+	testProjects(t, fileProjects{path: filepath.Join(t.TempDir(), "tasks.json")})
+}
+
+// TestDatabaseProjects runs the project tests against the database
+func TestDatabaseProjects(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "tasks.db"))
+	if err != nil {
+		t.Fatalf("NewDatabase() returned error: %v", err)
+	}
+	if err := db.InitSchema(); err != nil {
+		t.Fatalf("InitSchema() returned error: %v", err)
+	}
+	defer db.Close()
+	testProjects(t, db)
+}
+
+// testProjects checks that projects keep their tasks and ID sequences
+// apart and can be renamed and deleted
+func testProjects(t *testing.T, p Projects) {
+	if err := p.CreateProject("work"); err != nil {
+		t.Fatalf("CreateProject() returned error: %v", err)
+	}
+	names, err := p.ListProjects()
+	if err != nil || len(names) != 2 || names[0] != defaultProject || names[1] != "work" {
+		t.Fatalf("ListProjects() = %v, %v; want [default work]", names, err)
+	}
+
+	open := func(name string) TaskStore {
+		s, err := p.OpenProject(name)
+		if err != nil {
+			t.Fatalf("OpenProject(%q) returned error: %v", name, err)
+		}
+		return s
+	}
+	home, work := open(defaultProject), open("work")
+	for _, title := range []string{"Laundry", "Groceries"} {
+		if err := home.Create(&Task{Title: title}); err != nil {
+			t.Fatalf("Create() returned error: %v", err)
+		}
+	}
+	report := &Task{Title: "Report"}
+	if err := work.Create(report); err != nil {
+		t.Fatalf("Create() returned error: %v", err)
+	}
+	if report.ID != 1 {
+		t.Errorf("first task of a new project has ID %d; want 1", report.ID)
+	}
+	if tasks, _ := work.List(ListOptions{}); len(tasks) != 1 {
+		t.Errorf("work lists %d tasks; want 1", len(tasks))
+	}
+	work.Close()
+
+	if err := p.RenameProject("work", "job"); err != nil {
+		t.Fatalf("RenameProject() returned error: %v", err)
+	}
+	if _, err := p.OpenProject("work"); err != errProjectNotFound {
+		t.Errorf("OpenProject(old name) error = %v; want errProjectNotFound", err)
+	}
+	job := open("job")
+	if got, err := job.Get(1); err != nil || got.Title != "Report" {
+		t.Errorf("Get(1) after rename = %v, %v; want task Report", got, err)
+	}
+	job.Close()
+
+	if err := p.DeleteProject("job"); err != nil {
+		t.Fatalf("DeleteProject() returned error: %v", err)
+	}
+	if _, err := p.OpenProject("job"); err != errProjectNotFound {
+		t.Errorf("OpenProject(deleted) error = %v; want errProjectNotFound", err)
+	}
+	if tasks, _ := home.List(ListOptions{}); len(tasks) != 2 {
+		t.Errorf("default project lists %d tasks after the delete; want 2", len(tasks))
+	}
+}
+
+// deleteFailingStore is a store in which tasks cannot be deleted
+type deleteFailingStore struct {
+	TaskStore
+}
+
+// Delete fails
+func (s deleteFailingStore) Delete(id int) error {
+	return errors.New("disk full")
+}
+
+// Atomic runs fn on the failing store rather than the one it wraps
+func (s deleteFailingStore) Atomic(fn func(TaskStore) error) error {
+	return s.TaskStore.Atomic(func(TaskStore) error { return fn(s) })
+}
+
+// TestMoveTasks tests that moved tasks keep their subtasks and that a
+// move that fails halfway leaves both projects as they were
+func TestMoveTasks(t *testing.T) {
+	for _, fail := range []bool{false, true} {
+		source := TaskStore(NewMemoryStore())
+		if fail {
+			source = deleteFailingStore{source}
+		}
+		useStore(t, source)
+		target := NewMemoryStore()
+		openStores["work"] = target
+		defer delete(openStores, "work")
+
+		for _, task := range []*Task{{Title: "Plan"}, {Title: "Book flights", ParentID: 1}, {Title: "Stay"}} {
+			if err := createTask(task); err != nil {
+				t.Fatalf("createTask() returned error: %v", err)
+			}
+		}
+
+		moved, err := moveTasks([]int{1}, "work")
+		left, _ := allTasks()
+		copies, _ := target.List(ListOptions{})
+		if fail {
+			if err == nil {
+				t.Errorf("moveTasks() returned no error")
+			}
+			if len(left) != 3 || len(copies) != 0 {
+				t.Errorf("after a failed move %d tasks are left and %d copied; want 3 and 0", len(left), len(copies))
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("moveTasks() returned error: %v", err)
+		}
+		if len(moved) != 2 || len(left) != 1 || len(copies) != 2 {
+			t.Fatalf("moved %d tasks, left %d, copied %d; want 2, 1 and 2", len(moved), len(left), len(copies))
+		}
+		if copies[1].ParentID != copies[0].ID {
+			t.Errorf("parent of the moved subtask = %d; want %d", copies[1].ParentID, copies[0].ID)
+		}
+	}
+}
+
+// TestRenameProjectFailures tests that a rename that cannot move every
+// file of a project leaves the project under its old name
+func TestRenameProjectFailures(t *testing.T) {
+	path := useFileStorage(t)
+	s, err := projectStore(defaultProject)
+	if err != nil {
+		t.Fatalf("projectStore() returned error: %v", err)
+	}
+	useStore(t, s)
+	p := fileProjects{path: path}
+	if err := p.CreateProject("work"); err != nil {
+		t.Fatalf("CreateProject() returned error: %v", err)
+	}
+	work, err := projectStore("work")
+	if err != nil {
+		t.Fatalf("projectStore() returned error: %v", err)
+	}
+	if err := work.Create(&Task{Title: "Report"}); err != nil {
+		t.Fatalf("Create() returned error: %v", err)
+	}
+	if err := ioutil.WriteFile(projectSidecar("work", ".reminders"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A directory in the way makes moving one of the files fail
+	blocked := func(path string) func() {
+		if err := os.MkdirAll(filepath.Join(path, "in-the-way"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return func() { os.RemoveAll(path) }
+	}
+	for _, target := range []string{journalPath(p.projectPath("office")), projectSidecar("office", ".reminders")} {
+		unblock := blocked(target)
+		if err := renameProject("work", "office"); err == nil {
+			t.Errorf("renameProject() with %s in the way returned no error", filepath.Base(target))
+		}
+		unblock()
+		names, _ := p.ListProjects()
+		if strings.Join(names, ",") != "default,work" {
+			t.Errorf("projects after a failed rename = %v; want default and work", names)
+		}
+		if _, err := os.Stat(journalPath(p.projectPath("work"))); err != nil {
+			t.Errorf("journal of work after a failed rename: %v", err)
+		}
+	}
+
+	if err := renameProject("work", "office"); err != nil {
+		t.Fatalf("renameProject() returned error: %v", err)
+	}
+	if _, err := os.Stat(projectSidecar("office", ".reminders")); err != nil {
+		t.Errorf("reminders of the renamed project: %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of recurrence rules
+const (
+	RepeatDaily    = "daily"
+	RepeatWeekly   = "weekly"
+	RepeatMonthly  = "monthly"
+	RepeatInterval = "interval"
+)
+
+// Recurrence describes when a repeating task comes due again.
+// Rules are written as:
+//
+//	daily            every day
+//	weekly           every week on the weekday of the due date
+//	weekly:mon,thu   every week on the given weekdays
+//	monthly:15       every month on the 15th (or its last day if shorter)
+//	every 3 days     every N days
+type Recurrence struct {
+	Kind     string
+	Weekdays []time.Weekday
+	Day      int
+	Interval int
+}
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ParseRecurrence parses a recurrence rule
+func ParseRecurrence(s string) (*Recurrence, error) {
+	rule := strings.ToLower(strings.TrimSpace(s))
+	kind, arg := rule, ""
+	if colon := strings.Index(rule, ":"); colon >= 0 {
+		kind, arg = rule[:colon], rule[colon+1:]
+	}
+
+	switch {
+	case kind == RepeatDaily && arg == "":
+		return &Recurrence{Kind: RepeatDaily}, nil
+	case kind == RepeatWeekly:
+		r := &Recurrence{Kind: RepeatWeekly}
+		if arg == "" {
+			return r, nil
+		}
+		for _, name := range strings.Split(arg, ",") {
+			day, err := parseWeekday(name)
+			if err != nil {
+				return nil, err
+			}
+			r.Weekdays = append(r.Weekdays, day)
+		}
+		return r, nil
+	case kind == RepeatMonthly:
+		day, err := strconv.Atoi(arg)
+		if err != nil || day < 1 || day > 31 {
+			return nil, fmt.Errorf("monthly rules need a day of the month, e.g. monthly:15")
+		}
+		return &Recurrence{Kind: RepeatMonthly, Day: day}, nil
+	case strings.HasPrefix(rule, "every "):
+		fields := strings.Fields(rule)
+		if len(fields) == 3 && (fields[2] == "days" || fields[2] == "day") {
+			n, err := strconv.Atoi(fields[1])
+			if err == nil && n > 0 {
+				return &Recurrence{Kind: RepeatInterval, Interval: n}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("invalid recurrence %q (use daily, weekly[:mon,thu], monthly:DAY or every N days)", s)
+}
+
+// parseWeekday accepts weekday names shortened to at least three letters
+func parseWeekday(name string) (time.Weekday, error) {
+	name = strings.TrimSpace(name)
+	if len(name) >= 3 {
+		for day := time.Sunday; day <= time.Saturday; day++ {
+			if strings.HasPrefix(strings.ToLower(day.String()), name) {
+				return day, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unknown weekday %q", name)
+}
+
+// String formats the rule the way ParseRecurrence accepts it
+func (r Recurrence) String() string {
+	switch r.Kind {
+	case RepeatWeekly:
+		if len(r.Weekdays) == 0 {
+			return RepeatWeekly
+		}
+		var names []string
+		for _, day := range r.Weekdays {
+			names = append(names, weekdayNames[day])
+		}
+		return RepeatWeekly + ":" + strings.Join(names, ",")
+	case RepeatMonthly:
+		return fmt.Sprintf("%s:%d", RepeatMonthly, r.Day)
+	case RepeatInterval:
+		return fmt.Sprintf("every %d days", r.Interval)
+	}
+	return r.Kind
+}
+
+// MarshalText stores the rule in its written form in JSON
+func (r Recurrence) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText reads a rule in its written form
+func (r *Recurrence) UnmarshalText(text []byte) error {
+	parsed, err := ParseRecurrence(string(text))
+	if err != nil {
+		return err
+	}
+	*r = *parsed
+	return nil
+}
+
+// Next returns the first occurrence strictly after from, keeping the
+// time of day
+func (r Recurrence) Next(from time.Time) time.Time {
+	switch r.Kind {
+	case RepeatWeekly:
+		if len(r.Weekdays) == 0 {
+			return from.AddDate(0, 0, 7)
+		}
+		for days := 1; days <= 7; days++ {
+			next := from.AddDate(0, 0, days)
+			for _, day := range r.Weekdays {
+				if next.Weekday() == day {
+					return next
+				}
+			}
+		}
+	case RepeatMonthly:
+		next := monthDay(from.Year(), from.Month(), r.Day, from)
+		if !next.After(from) {
+			next = monthDay(from.Year(), from.Month()+1, r.Day, from)
+		}
+		return next
+	case RepeatInterval:
+		return from.AddDate(0, 0, r.Interval)
+	}
+	return from.AddDate(0, 0, 1)
+}
+
+// NextAfter returns the first occurrence after from that is also later
+// than now, so a task completed late is not recreated already overdue
+func (r Recurrence) NextAfter(from, now time.Time) time.Time {
+	next := r.Next(from)
+	for !next.After(now) {
+		next = r.Next(next)
+	}
+	return next
+}
+
+// monthDay returns day of the given month at the clock time of clock,
+// moved back to the month's last day when the month is shorter
+func monthDay(year int, month time.Month, day int, clock time.Time) time.Time {
+	first := time.Date(year, month, 1, clock.Hour(), clock.Minute(), clock.Second(), 0, clock.Location())
+	last := first.AddDate(0, 1, -1).Day()
+	if day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRecurrenceNext tests the next occurrence of each kind of rule
+func TestRecurrenceNext(t *testing.T) {
+	// 2024-01-31 is a Wednesday
+	from := time.Date(2024, 1, 31, 9, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		rule string
+		want string
+	}{
+		{"daily", "2024-02-01 09:30"},
+		{"every 3 days", "2024-02-03 09:30"},
+		{"weekly", "2024-02-07 09:30"},
+		{"weekly:mon,thu", "2024-02-01 09:30"},
+		{"weekly:wed", "2024-02-07 09:30"},
+		{"monthly:31", "2024-02-29 09:30"},
+		{"monthly:15", "2024-02-15 09:30"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			rule, err := ParseRecurrence(tt.rule)
+			if err != nil {
+				t.Fatalf("ParseRecurrence(%q) returned error: %v", tt.rule, err)
+			}
+			got := rule.Next(from).Format("2006-01-02 15:04")
+			if got != tt.want {
+				t.Errorf("Next(%v) = %s; want %s", from, got, tt.want)
+			}
+			if rule.String() != tt.rule {
+				t.Errorf("String() = %q; want %q", rule.String(), tt.rule)
+			}
+		})
+	}
+}
+
+// TestRecurrenceNextAfter tests that late completion skips missed dates
+func TestRecurrenceNextAfter(t *testing.T) {
+	rule, _ := ParseRecurrence("weekly:mon")
+	due := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	now := time.Date(2024, 1, 17, 8, 0, 0, 0, time.UTC)
+
+	got := rule.NextAfter(due, now)
+	want := time.Date(2024, 1, 22, 10, 0, 0, 0, time.UTC)
+	if !got.Equal(want) {
+		t.Errorf("NextAfter() = %v; want %v", got, want)
+	}
+}
+
+// TestParseRecurrenceErrors tests that malformed rules are rejected
+func TestParseRecurrenceErrors(t *testing.T) {
+	for _, rule := range []string{"", "hourly", "weekly:xyz", "monthly", "monthly:32", "every 0 days", "every week"} {
+		if _, err := ParseRecurrence(rule); err == nil {
+			t.Errorf("ParseRecurrence(%q) returned no error", rule)
+		}
+	}
+}
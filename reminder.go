@@ -0,0 +1,430 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultLeadTimes are how long before the due date reminders are sent
+const defaultLeadTimes = "24h,1h,0"
+
+// defaultSnooze is how long a reminder is put off when no time is given
+const defaultSnooze = 15 * time.Minute
+
+// reminderInterval is how often the scheduler looks at due dates
+const reminderInterval = 30 * time.Second
+
+// storeLock serialises access to the tasks between the interactive
+// interface and the reminder scheduler
+var storeLock sync.Mutex
+
+// Reminder is a notice that a task is coming due or is overdue
+type Reminder struct {
+	Project string
+	Task    Task
+	Lead    time.Duration
+	// at is the time recorded as sent once the reminder is delivered
+	at time.Time
+}
+
+// Message describes the reminder, e.g. `Task 3 "Pay rent" is due in 1h`.
+// Tasks of projects other than the default one name their project.
+func (r Reminder) Message(now time.Time) string {
+	left := r.Task.DueDate.Sub(now)
+	when := "is due now"
+	switch {
+	case left >= time.Minute:
+		when = "is due in " + formatDuration(left)
+	case left <= -time.Minute:
+		when = "is overdue by " + formatDuration(-left)
+	}
+	task := fmt.Sprintf("Task %d", r.Task.ID)
+	if r.Project != "" && r.Project != defaultProject {
+		task += " of project " + r.Project
+	}
+	return fmt.Sprintf("%s %q %s (%s)", task, r.Task.Title, when, formatDueDate(r.Task.DueDate))
+}
+
+// Notifier delivers reminders to the user
+type Notifier interface {
+	Notify(r Reminder) error
+}
+
+// terminalNotifier rings the terminal bell and prints the reminder
+type terminalNotifier struct {
+	w io.Writer
+}
+
+// Notify writes the reminder on a line of its own
+func (n terminalNotifier) Notify(r Reminder) error {
+	_, err := fmt.Fprintf(n.w, "\a\n%s %s\n", colorize(ansiYellow, "Reminder:"), r.Message(time.Now()))
+	return err
+}
+
+// commandNotifier runs a shell command for every reminder. The command
+// finds the details in the TASK_ID, TASK_TITLE, TASK_DUE, TASK_PROJECT
+// and TASK_MESSAGE environment variables.
+type commandNotifier struct {
+	command string
+}
+
+// Notify runs the command and waits for it to finish
+func (n commandNotifier) Notify(r Reminder) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/c", n.command)
+	} else {
+		cmd = exec.Command("sh", "-c", n.command)
+	}
+	cmd.Env = append(os.Environ(),
+		"TASK_ID="+strconv.Itoa(r.Task.ID),
+		"TASK_TITLE="+r.Task.Title,
+		"TASK_DUE="+formatDueDate(r.Task.DueDate),
+		"TASK_PROJECT="+r.Project,
+		"TASK_MESSAGE="+r.Message(time.Now()),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("notify command failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// reminderEntry is what has been reminded about one task
+type reminderEntry struct {
+	Due          time.Time  `json:"due"`
+	Sent         time.Time  `json:"sent"`
+	SnoozedUntil *time.Time `json:"snoozed_until,omitempty"`
+}
+
+// Reminders tracks which reminders were sent, so none is sent twice,
+// also across restarts. The state is kept in its own file rather than
+// on the tasks, so sending a reminder never gets in the way of undo.
+type Reminders struct {
+	path    string
+	leads   []time.Duration
+	entries map[int]*reminderEntry
+}
+
+// loadReminders reads the reminder state at path. A missing file starts
+// with nothing sent. leads must be ordered from longest to shortest.
+func loadReminders(path string, leads []time.Duration) (*Reminders, error) {
+	r := &Reminders{path: path, leads: leads, entries: map[int]*reminderEntry{}}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, &r.entries); err != nil {
+		return nil, fmt.Errorf("%s is corrupt: %v", path, err)
+	}
+	return r, nil
+}
+
+// save writes the reminder state to its file. A truncated file would
+// stop every later check, so it is replaced rather than overwritten.
+func (r *Reminders) save() error {
+	data, err := json.MarshalIndent(r.entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding reminders: %v", err)
+	}
+	if err := replaceFile(r.path, data); err != nil {
+		return fmt.Errorf("error saving reminders: %v", err)
+	}
+	return nil
+}
+
+// entry returns the state for task, starting afresh when its due date
+// has changed since the last reminder
+func (r *Reminders) entry(task Task) *reminderEntry {
+	e := r.entries[task.ID]
+	if e == nil || !e.Due.Equal(*task.DueDate) {
+		e = &reminderEntry{Due: *task.DueDate}
+		r.entries[task.ID] = e
+	}
+	return e
+}
+
+// Due returns the reminders to send at now; MarkSent records them once
+// they are delivered. When several lead times have passed since the last
+// check, as after a restart, only the latest is sent. Completed tasks
+// and tasks without a due date are forgotten.
+func (r *Reminders) Due(tasks []Task, now time.Time) []Reminder {
+	var due []Reminder
+	seen := map[int]bool{}
+	for _, task := range tasks {
+		if task.Completed || task.DueDate == nil {
+			continue
+		}
+		seen[task.ID] = true
+		e := r.entry(task)
+
+		if e.SnoozedUntil != nil {
+			if now.Before(*e.SnoozedUntil) {
+				continue
+			}
+			// A snoozed reminder comes back once, if one is due by now
+			if len(r.leads) > 0 && !task.DueDate.Add(-r.leads[0]).After(now) {
+				due = append(due, Reminder{Task: task, Lead: task.DueDate.Sub(now), at: now})
+				continue
+			}
+			e.SnoozedUntil = nil
+		}
+
+		var send *Reminder
+		for _, lead := range r.leads {
+			at := task.DueDate.Add(-lead)
+			if !at.After(now) && at.After(e.Sent) {
+				send = &Reminder{Task: task, Lead: lead, at: at}
+			}
+		}
+		if send != nil {
+			due = append(due, *send)
+		}
+	}
+	for id := range r.entries {
+		if !seen[id] {
+			delete(r.entries, id)
+		}
+	}
+	return due
+}
+
+// MarkSent records that reminder was delivered, so it is not sent again
+func (r *Reminders) MarkSent(reminder Reminder) {
+	e := r.entry(reminder.Task)
+	e.Sent = reminder.at
+	e.SnoozedUntil = nil
+}
+
+// Snooze puts off reminders for task until the given time
+func (r *Reminders) Snooze(task Task, until time.Time) error {
+	if task.DueDate == nil {
+		return fmt.Errorf("task %d has no due date", task.ID)
+	}
+	if task.Completed {
+		return fmt.Errorf("task %d is already completed", task.ID)
+	}
+	r.entry(task).SnoozedUntil = &until
+	return nil
+}
+
+// parseLeadTimes parses a comma-separated list of durations such as
+// "1d,2h,30m,0" and orders them from longest to shortest
+func parseLeadTimes(s string) ([]time.Duration, error) {
+	var leads []time.Duration
+	for _, field := range strings.Split(s, ",") {
+		lead, err := parseDuration(field)
+		if err != nil {
+			return nil, err
+		}
+		if lead < 0 {
+			return nil, fmt.Errorf("lead time %q is negative", strings.TrimSpace(field))
+		}
+		leads = append(leads, lead)
+	}
+	sort.Slice(leads, func(i, j int) bool { return leads[i] > leads[j] })
+	return leads, nil
+}
+
+// parseDuration parses a Go duration such as "1h30m", also accepting
+// whole days written as "2d"
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err == nil {
+			return time.Duration(days) * 24 * time.Hour, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q (use e.g. 2d, 1h, 30m)", s)
+	}
+	return d, nil
+}
+
+// formatDuration renders d in days, hours and minutes, e.g. "1d 2h"
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	days := d / (24 * time.Hour)
+	hours := d % (24 * time.Hour) / time.Hour
+	minutes := d % time.Hour / time.Minute
+
+	var parts []string
+	if days > 0 {
+		parts = append(parts, fmt.Sprintf("%dd", days))
+	}
+	if hours > 0 {
+		parts = append(parts, fmt.Sprintf("%dh", hours))
+	}
+	if minutes > 0 && days == 0 {
+		parts = append(parts, fmt.Sprintf("%dm", minutes))
+	}
+	if len(parts) == 0 {
+		return "0m"
+	}
+	return strings.Join(parts, " ")
+}
+
+// Scheduler checks the due dates of every project in the background and
+// sends reminders
+type Scheduler struct {
+	leads    []time.Duration
+	notifier Notifier
+	onError  func(error)
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewScheduler creates a scheduler that reports problems to onError
+func NewScheduler(leads []time.Duration, notifier Notifier, onError func(error)) *Scheduler {
+	return &Scheduler{leads: leads, notifier: notifier, onError: onError}
+}
+
+// Start checks for reminders now and then every reminderInterval until
+// Stop is called
+func (s *Scheduler) Start() {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(reminderInterval)
+		defer ticker.Stop()
+		for {
+			if err := s.Check(time.Now()); err != nil {
+				s.onError(err)
+			}
+			select {
+			case <-ticker.C:
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the background checks and waits for a running one to finish
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+// Check sends the reminders due at now. Each one is recorded as sent
+// only once it is delivered, so one that fails is tried again at the
+// next check; the others are still sent.
+func (s *Scheduler) Check(now time.Time) error {
+	due, err := s.collect(now)
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, r := range due {
+		if err := s.notifier.Notify(r); err != nil {
+			failed = append(failed, err.Error())
+			continue
+		}
+		if err := s.markSent(r); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d reminder(s) failed: %s", len(failed), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// markSent records reminder r as delivered
+func (s *Scheduler) markSent(r Reminder) error {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	reminders, err := loadReminders(projectSidecar(r.Project, ".reminders"), s.leads)
+	if err != nil {
+		return err
+	}
+	reminders.MarkSent(r)
+	return reminders.save()
+}
+
+// collect returns the reminders due at now in every project, without
+// recording them as sent. The tasks and the state are read again every
+// time, so tasks, due dates and snoozes changed by another process are
+// seen.
+func (s *Scheduler) collect(now time.Time) ([]Reminder, error) {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	names, err := projects.ListProjects()
+	if err != nil {
+		return nil, err
+	}
+	var due []Reminder
+	for _, name := range names {
+		reminders, err := loadReminders(projectSidecar(name, ".reminders"), s.leads)
+		if err != nil {
+			return nil, err
+		}
+		// Not projectStore, which keeps the tasks as they were first read
+		store, err := projects.OpenProject(name)
+		if err == errProjectNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		tasks, err := store.List(ListOptions{})
+		store.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range reminders.Due(tasks, now) {
+			r.Project = name
+			due = append(due, r)
+		}
+		if err := reminders.save(); err != nil {
+			return nil, err
+		}
+	}
+	return due, nil
+}
+
+// newScheduler creates the scheduler set up by the -remind and -notify
+// flags. Reminders go to notifier unless -notify names a command.
+func newScheduler(notifier Notifier, onError func(error)) (*Scheduler, error) {
+	leads, err := parseLeadTimes(remindLeads)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -remind: %v", err)
+	}
+	if notifyCommand != "" {
+		notifier = commandNotifier{command: notifyCommand}
+	}
+	return NewScheduler(leads, notifier, onError), nil
+}
+
+// snoozeTask puts off the reminders for task id by d and returns when
+// they resume
+func snoozeTask(id int, d time.Duration) (time.Time, error) {
+	task, err := getTask(id)
+	if err != nil {
+		return time.Time{}, err
+	}
+	reminders, err := loadReminders(projectSidecar(currentProject, ".reminders"), nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	until := time.Now().Add(d)
+	if err := reminders.Snooze(*task, until); err != nil {
+		return time.Time{}, err
+	}
+	return until, reminders.save()
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestRemindersDue tests that each reminder is sent once, at the right
+// lead time, and that snoozing puts it off
+func TestRemindersDue(t *testing.T) {
+	due := time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC)
+	task := Task{ID: 1, Title: "Pay rent", DueDate: &due}
+	leads, _ := parseLeadTimes("1d,1h,0")
+	r, err := loadReminders(filepath.Join(t.TempDir(), "reminders"), leads)
+	if err != nil {
+		t.Fatalf("loadReminders() returned error: %v", err)
+	}
+
+	steps := []struct {
+		at     string
+		snooze string
+		want   int
+	}{
+		{"2024-06-01 12:00", "", 0},
+		{"2024-06-02 12:00", "", 1},
+		{"2024-06-02 13:00", "", 0},
+		{"2024-06-03 11:10", "", 1},
+		{"2024-06-03 11:20", "30m", 0},
+		{"2024-06-03 11:40", "", 0},
+		{"2024-06-03 11:51", "", 1},
+		{"2024-06-03 11:55", "", 0},
+		{"2024-06-03 12:00", "", 1},
+		{"2024-06-04 12:00", "", 0},
+	}
+	for _, step := range steps {
+		now, _ := time.Parse("2006-01-02 15:04", step.at)
+		if step.snooze != "" {
+			d, _ := parseDuration(step.snooze)
+			if err := r.Snooze(task, now.Add(d)); err != nil {
+				t.Fatalf("Snooze() returned error: %v", err)
+			}
+		}
+		due := r.Due([]Task{task}, now)
+		if len(due) != step.want {
+			t.Errorf("at %s: Due() sent %d reminder(s); want %d", step.at, len(due), step.want)
+		}
+		for _, reminder := range due {
+			r.MarkSent(reminder)
+		}
+	}
+
+	// A restart must not repeat reminders that were already sent
+	if err := r.save(); err != nil {
+		t.Fatalf("save() returned error: %v", err)
+	}
+	restarted, err := loadReminders(r.path, leads)
+	if err != nil {
+		t.Fatalf("loadReminders() returned error: %v", err)
+	}
+	now := time.Date(2024, 6, 4, 13, 0, 0, 0, time.UTC)
+	if got := len(restarted.Due([]Task{task}, now)); got != 0 {
+		t.Errorf("after restart Due() sent %d reminder(s); want 0", got)
+	}
+}
+
+// TestParseDuration tests durations with and without whole days
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		input string
+		want  time.Duration
+	}{
+		{"30m", 30 * time.Minute},
+		{"2h", 2 * time.Hour},
+		{"1d", 24 * time.Hour},
+		{" 3d ", 72 * time.Hour},
+		{"0", 0},
+	}
+	for _, tt := range tests {
+		got, err := parseDuration(tt.input)
+		if err != nil {
+			t.Errorf("parseDuration(%q) returned error: %v", tt.input, err)
+		} else if got != tt.want {
+			t.Errorf("parseDuration(%q) = %v; want %v", tt.input, got, tt.want)
+		}
+	}
+	if _, err := parseDuration("soon"); err == nil {
+		t.Errorf("parseDuration(%q) returned no error", "soon")
+	}
+}
+
+// useFileStorage keeps the projects in a JSON file in a temporary
+// directory for the rest of the test and returns its path
+func useFileStorage(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tasks.json")
+	savedPath, savedProjects := storagePath, projects
+	storagePath, projects = path, fileProjects{path: path}
+	t.Cleanup(func() {
+		for name := range openStores {
+			delete(openStores, name)
+		}
+		storagePath, projects = savedPath, savedProjects
+	})
+	return path
+}
+
+// flakyNotifier fails to deliver reminders for the tasks in fail
+type flakyNotifier struct {
+	fail map[int]bool
+	sent []int
+}
+
+func (n *flakyNotifier) Notify(r Reminder) error {
+	if n.fail[r.Task.ID] {
+		return fmt.Errorf("task %d: no connection", r.Task.ID)
+	}
+	n.sent = append(n.sent, r.Task.ID)
+	return nil
+}
+
+// TestSchedulerCheck tests that a reminder that fails to be delivered
+// neither holds up the others nor is lost
+func TestSchedulerCheck(t *testing.T) {
+	path := useFileStorage(t)
+	now := time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC)
+	due := now.Add(30 * time.Minute)
+	tasks, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() returned error: %v", err)
+	}
+	for _, title := range []string{"Call", "Pay", "Post"} {
+		tasks.Create(&Task{Title: title, DueDate: &due})
+	}
+
+	leads, _ := parseLeadTimes("1h")
+	notifier := &flakyNotifier{fail: map[int]bool{1: true}}
+	scheduler := NewScheduler(leads, notifier, nil)
+	if err := scheduler.Check(now); err == nil {
+		t.Errorf("Check() returned no error for the failed reminder")
+	}
+	if fmt.Sprint(notifier.sent) != "[2 3]" {
+		t.Errorf("first Check() sent %v; want [2 3]", notifier.sent)
+	}
+
+	notifier.fail, notifier.sent = nil, nil
+	if err := scheduler.Check(now.Add(time.Minute)); err != nil {
+		t.Errorf("Check() returned error: %v", err)
+	}
+	if fmt.Sprint(notifier.sent) != "[1]" {
+		t.Errorf("second Check() sent %v; want only the failed [1] again", notifier.sent)
+	}
+}
+
+// TestSchedulerSeesChanges tests that a running scheduler picks up tasks
+// and due dates another process changed since its last check
+func TestSchedulerSeesChanges(t *testing.T) {
+	path := useFileStorage(t)
+	now := time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC)
+	later := now.Add(48 * time.Hour)
+	first, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() returned error: %v", err)
+	}
+	first.Create(&Task{Title: "Call", DueDate: &later})
+
+	leads, _ := parseLeadTimes("1h")
+	notifier := &flakyNotifier{}
+	scheduler := NewScheduler(leads, notifier, nil)
+	if err := scheduler.Check(now); err != nil || len(notifier.sent) != 0 {
+		t.Fatalf("first Check() = %v and sent %v; want nothing sent", err, notifier.sent)
+	}
+
+	// Another process brings the task forward and adds one
+	other, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() returned error: %v", err)
+	}
+	soon := now.Add(30 * time.Minute)
+	task, _ := other.Get(1)
+	task.DueDate = &soon
+	other.Update(task)
+	other.Create(&Task{Title: "Pay", DueDate: &soon})
+
+	if err := scheduler.Check(now.Add(time.Minute)); err != nil {
+		t.Fatalf("second Check() returned error: %v", err)
+	}
+	if fmt.Sprint(notifier.sent) != "[1 2]" {
+		t.Errorf("second Check() sent %v; want [1 2]", notifier.sent)
+	}
+}
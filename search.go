@@ -0,0 +1,146 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Matches in the title count for more than matches in the description,
+// and a term that is a whole word beats one that is only a prefix
+const (
+	titleMatchScore       = 3
+	descriptionMatchScore = 1
+	wholeWordBonus        = 1
+)
+
+// SearchResult is a task found by Search with its relevance score
+type SearchResult struct {
+	Task  Task `json:"task"`
+	Score int  `json:"score"`
+}
+
+// searchTerms splits a query into lowercase words
+func searchTerms(query string) []string {
+	var terms []string
+	for _, word := range wordSpans([]rune(strings.ToLower(query))) {
+		terms = append(terms, word.text)
+	}
+	return terms
+}
+
+// wordSpan is one word of a text and the rune offset it starts at
+type wordSpan struct {
+	start int
+	text  string
+}
+
+// wordSpans splits text into runs of letters and digits
+func wordSpans(text []rune) []wordSpan {
+	var spans []wordSpan
+	start := -1
+	for i := 0; i <= len(text); i++ {
+		inWord := i < len(text) && (unicode.IsLetter(text[i]) || unicode.IsDigit(text[i]))
+		if inWord && start < 0 {
+			start = i
+		}
+		if !inWord && start >= 0 {
+			spans = append(spans, wordSpan{start: start, text: string(text[start:i])})
+			start = -1
+		}
+	}
+	return spans
+}
+
+// lowerRunes lowercases text rune by rune so offsets stay aligned with
+// the original
+func lowerRunes(text string) []rune {
+	runes := []rune(text)
+	for i, r := range runes {
+		runes[i] = unicode.ToLower(r)
+	}
+	return runes
+}
+
+// termScore scores how well term matches the words of text: each word
+// starting with term counts, and whole-word matches earn a bonus
+func termScore(term string, text string, weight int) int {
+	score := 0
+	for _, word := range wordSpans(lowerRunes(text)) {
+		if !strings.HasPrefix(word.text, term) {
+			continue
+		}
+		score += weight
+		if word.text == term {
+			score += wholeWordBonus
+		}
+	}
+	return score
+}
+
+// Search returns the tasks in which every word of query starts a word of
+// the title or description, ignoring case, most relevant first
+func Search(tasks []Task, query string) []SearchResult {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	var results []SearchResult
+	for _, task := range tasks {
+		total := 0
+		for _, term := range terms {
+			score := termScore(term, task.Title, titleMatchScore) +
+				termScore(term, task.Description, descriptionMatchScore)
+			if score == 0 {
+				total = 0
+				break
+			}
+			total += score
+		}
+		if total > 0 {
+			results = append(results, SearchResult{Task: task, Score: total})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Task.ID < results[j].Task.ID
+	})
+	return results
+}
+
+// highlightTerms marks the parts of text matched by the query terms,
+// using color when it is enabled and brackets otherwise
+func highlightTerms(text string, terms []string) string {
+	original := []rune(text)
+	var b strings.Builder
+	last := 0
+	for _, word := range wordSpans(lowerRunes(text)) {
+		length := 0
+		for _, term := range terms {
+			if strings.HasPrefix(word.text, term) && len([]rune(term)) > length {
+				length = len([]rune(term))
+			}
+		}
+		if length == 0 {
+			continue
+		}
+		end := word.start + length
+		b.WriteString(string(original[last:word.start]))
+		b.WriteString(highlight(string(original[word.start:end])))
+		last = end
+	}
+	b.WriteString(string(original[last:]))
+	return b.String()
+}
+
+// highlight emphasises a matched piece of text
+func highlight(s string) string {
+	if !colorEnabled {
+		return "[" + s + "]"
+	}
+	return ansiBold + ansiYellow + s + ansiReset
+}
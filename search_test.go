@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestSearch tests that results match every term, ignore case and come
+// most relevant first
+func TestSearch(t *testing.T) {
+	tasks := []Task{
+		{ID: 1, Title: "Call the bank"},
+		{ID: 2, Title: "Banking app"},
+		{ID: 3, Title: "File papers", Description: "the bank statement"},
+		{ID: 4, Title: "Bank, then bank again"},
+		{ID: 5, Title: "Sandbank trip"},
+		{ID: 6, Title: "BANK holiday"},
+		{ID: 7, Title: "Über Straße", Description: "call Ünal"},
+	}
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		// Whole title words first, then prefixes, then the description;
+		// ties go by ID
+		{"bank", []int{4, 1, 6, 2, 3}},
+		{"BANK", []int{4, 1, 6, 2, 3}},
+		{"call bank", []int{1}},
+		{"über", []int{7}},
+		{"ÜBER ünal", []int{7}},
+		{"bankrupt", nil},
+		{"  ", nil},
+	}
+	for _, tt := range tests {
+		var got []int
+		for _, result := range Search(tasks, tt.query) {
+			got = append(got, result.Task.ID)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("Search(%q) = %v; want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+// TestHighlightTerms tests that the longest matching term is marked at
+// the start of each word, also where terms overlap
+func TestHighlightTerms(t *testing.T) {
+	saved := colorEnabled
+	colorEnabled = false
+	defer func() { colorEnabled = saved }()
+
+	tests := []struct {
+		text  string
+		terms []string
+		want  string
+	}{
+		{"Call the Bank", []string{"bank"}, "Call the [Bank]"},
+		{"Bank banking", []string{"ba", "bank"}, "[Bank] [bank]ing"},
+		{"Bank banking", []string{"banking", "b"}, "[B]ank [banking]"},
+		{"Sandbank trip", []string{"bank"}, "Sandbank trip"},
+		{"Grüße an Über", []string{"über", "gr"}, "[Gr]üße an [Über]"},
+		{"no match", []string{"x"}, "no match"},
+	}
+	for _, tt := range tests {
+		if got := highlightTerms(tt.text, tt.terms); got != tt.want {
+			t.Errorf("highlightTerms(%q, %q) = %q; want %q", tt.text, tt.terms, got, tt.want)
+		}
+	}
+}
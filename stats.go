@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// burndownHeight is the number of rows of the burndown chart
+const burndownHeight = 10
+
+// reportDays is the length of the default report period
+const reportDays = 28
+
+// burndownWidth is the most columns the burndown chart uses; longer
+// periods show one column for several days
+const burndownWidth = 60
+
+// weekCount is the number of tasks completed in the week starting on a
+// Monday
+type weekCount struct {
+	Week      string `json:"week"`
+	Completed int    `json:"completed"`
+}
+
+// burndownPoint is the number of tasks open at the end of a day
+type burndownPoint struct {
+	Date string `json:"date"`
+	Open int    `json:"open"`
+}
+
+// Report summarises how tasks were created and completed between two
+// dates. Tasks from before timestamps were recorded only count towards
+// the burndown, as open until they were completed.
+type Report struct {
+	From           string          `json:"from"`
+	To             string          `json:"to"`
+	Created        int             `json:"created"`
+	Completed      int             `json:"completed"`
+	CompletionRate float64         `json:"completion_rate"`
+	AverageTime    time.Duration   `json:"-"`
+	AverageHours   float64         `json:"average_hours_to_complete"`
+	Weeks          []weekCount     `json:"weeks"`
+	Burndown       []burndownPoint `json:"burndown"`
+}
+
+// buildReport reports on tasks for the days from to to, both included.
+// Created counts the tasks created in the period and CompletionRate the
+// share of them completed by now; Completed and AverageTime are about
+// the tasks completed in the period. Deleted tasks count as open until
+// they were deleted.
+func buildReport(tasks []Task, from, to, now time.Time) Report {
+	from, to = startOfDay(from), startOfDay(to)
+	end := to.AddDate(0, 0, 1)
+	inPeriod := func(t *time.Time) bool {
+		return t != nil && !t.Before(from) && t.Before(end)
+	}
+	report := Report{From: from.Format("2006-01-02"), To: to.Format("2006-01-02"), Weeks: []weekCount{}, Burndown: []burndownPoint{}}
+
+	createdDone := 0
+	var total time.Duration
+	timed := 0
+	for _, task := range tasks {
+		if inPeriod(task.CreatedAt) {
+			report.Created++
+			if task.Completed {
+				createdDone++
+			}
+		}
+		if inPeriod(task.CompletedAt) {
+			report.Completed++
+			if task.CreatedAt != nil && task.CompletedAt.After(*task.CreatedAt) {
+				total += task.CompletedAt.Sub(*task.CreatedAt)
+				timed++
+			}
+		}
+	}
+	if report.Created > 0 {
+		report.CompletionRate = float64(createdDone) / float64(report.Created)
+	}
+	if timed > 0 {
+		report.AverageTime = total / time.Duration(timed)
+		report.AverageHours = report.AverageTime.Hours()
+	}
+
+	for week := thisWeek(from); week.Before(end); week = week.AddDate(0, 0, 7) {
+		count := weekCount{Week: week.Format("2006-01-02")}
+		weekEnd := week.AddDate(0, 0, 7)
+		for _, task := range tasks {
+			if task.CompletedAt != nil && !task.CompletedAt.Before(week) && task.CompletedAt.Before(weekEnd) {
+				count.Completed++
+			}
+		}
+		report.Weeks = append(report.Weeks, count)
+	}
+
+	for day := from; day.Before(end) && !day.After(now); day = day.AddDate(0, 0, 1) {
+		at := day.AddDate(0, 0, 1)
+		if at.After(now) {
+			at = now
+		}
+		point := burndownPoint{Date: day.Format("2006-01-02")}
+		for _, task := range tasks {
+			if openAt(task, at) {
+				point.Open++
+			}
+		}
+		report.Burndown = append(report.Burndown, point)
+	}
+	return report
+}
+
+// openAt reports whether task was open at t. Completed tasks without a
+// completion time are taken to have been completed before any report.
+func openAt(task Task, t time.Time) bool {
+	if task.CreatedAt != nil && task.CreatedAt.After(t) {
+		return false
+	}
+	if task.DeletedAt != nil && !task.DeletedAt.After(t) {
+		return false
+	}
+	if task.Completed {
+		return task.CompletedAt != nil && task.CompletedAt.After(t)
+	}
+	return true
+}
+
+// printReport writes the report with a burndown chart of open tasks
+func printReport(w io.Writer, r Report) {
+	fmt.Fprintf(w, "Report %s to %s\n\n", r.From, r.To)
+	fmt.Fprintf(w, "Tasks created:        %d\n", r.Created)
+	fmt.Fprintf(w, "Completion rate:      %.0f%% of the tasks created\n", r.CompletionRate*100)
+	fmt.Fprintf(w, "Tasks completed:      %d\n", r.Completed)
+	average := "-"
+	if r.AverageTime >= time.Minute {
+		average = formatDuration(r.AverageTime)
+	} else if r.AverageTime > 0 {
+		average = "under 1m"
+	}
+	fmt.Fprintf(w, "Average time to done: %s\n", average)
+
+	fmt.Fprintln(w, "\nCompleted per week")
+	for _, week := range r.Weeks {
+		fmt.Fprintln(w, strings.TrimRight(fmt.Sprintf("  %s  %3d %s", week.Week, week.Completed, strings.Repeat("#", week.Completed)), " "))
+	}
+
+	fmt.Fprintln(w, "\nOpen tasks")
+	printBurndown(w, r.Burndown)
+}
+
+// printBurndown draws the number of open tasks per day as columns of '#'
+func printBurndown(w io.Writer, points []burndownPoint) {
+	if len(points) == 0 {
+		fmt.Fprintln(w, "  No days to show.")
+		return
+	}
+	step := (len(points) + burndownWidth - 1) / burndownWidth
+	var columns []int
+	for i := 0; i < len(points); i += step {
+		columns = append(columns, points[i].Open)
+	}
+	max := 0
+	for _, open := range columns {
+		if open > max {
+			max = open
+		}
+	}
+	height := burndownHeight
+	if max < height {
+		height = max
+	}
+
+	for row := height; row >= 1; row-- {
+		// A column reaches this row when it fills at least its share
+		threshold := float64(row) * float64(max) / float64(height)
+		var line strings.Builder
+		for _, open := range columns {
+			if float64(open) >= threshold-1e-9 {
+				line.WriteByte('#')
+			} else {
+				line.WriteByte(' ')
+			}
+		}
+		fmt.Fprintf(w, "%5.0f |%s\n", threshold, strings.TrimRight(line.String(), " "))
+	}
+	fmt.Fprintf(w, "%5d +%s\n", 0, strings.Repeat("-", len(columns)))
+	first, last := points[0].Date, points[(len(columns)-1)*step].Date
+	if first == last {
+		fmt.Fprintf(w, "       %s\n", first)
+		return
+	}
+	gap := len(columns) - len(first) - len(last)
+	if gap < 1 {
+		gap = 1
+	}
+	fmt.Fprintf(w, "       %s%s%s\n", first, strings.Repeat(" ", gap), last)
+}
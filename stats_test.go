@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBuildReport tests the counts, rates and burndown of a report
+func TestBuildReport(t *testing.T) {
+	at := func(day, hour int) *time.Time {
+		t := time.Date(2024, 6, day, hour, 0, 0, 0, time.Local)
+		return &t
+	}
+	// June 3rd 2024 is a Monday
+	tasks := []Task{
+		{ID: 1, Title: "Old", Completed: true},
+		{ID: 2, Title: "Legacy"},
+		{ID: 3, Title: "Quick", CreatedAt: at(3, 9), Completed: true, CompletedAt: at(3, 15)},
+		{ID: 4, Title: "Slow", CreatedAt: at(3, 10), Completed: true, CompletedAt: at(11, 10)},
+		{ID: 5, Title: "Open", CreatedAt: at(4, 9)},
+		{ID: 6, Title: "Dropped", CreatedAt: at(4, 9), DeletedAt: at(5, 9)},
+	}
+
+	report := buildReport(tasks, *at(3, 0), *at(5, 0), *at(12, 0))
+
+	if report.Created != 4 || report.Completed != 1 {
+		t.Errorf("created %d, completed %d; want 4 and 1", report.Created, report.Completed)
+	}
+	if report.CompletionRate != 0.5 {
+		t.Errorf("completion rate = %v; want 0.5", report.CompletionRate)
+	}
+	if report.AverageTime != 6*time.Hour {
+		t.Errorf("average time = %v; want 6h", report.AverageTime)
+	}
+	if len(report.Weeks) != 1 || report.Weeks[0].Week != "2024-06-03" || report.Weeks[0].Completed != 1 {
+		t.Errorf("weeks = %+v; want one week with 1 completed", report.Weeks)
+	}
+
+	// Legacy is open throughout, Slow until the 11th, Open from the 4th
+	// and Dropped only on the 4th
+	var open []int
+	for _, point := range report.Burndown {
+		open = append(open, point.Open)
+	}
+	if !equalIDs(open, []int{2, 4, 3}) {
+		t.Errorf("burndown = %v; want [2 4 3]", open)
+	}
+
+	var out bytes.Buffer
+	printReport(&out, report)
+	if !strings.Contains(out.String(), "###") {
+		t.Errorf("printReport() drew no chart:\n%s", out.String())
+	}
+}
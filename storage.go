@@ -0,0 +1,408 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// taskFile is the on-disk layout of the tasks JSON file, which is a
+// snapshot of the tasks: the changes since are kept in its journal
+type taskFile struct {
+	NextID int    `json:"next_id"`
+	Tasks  []Task `json:"tasks"`
+	// JournalSeq is the last journal event the snapshot includes
+	JournalSeq  int        `json:"journal_seq,omitempty"`
+	CompactedAt *time.Time `json:"compacted_at,omitempty"`
+}
+
+// FileStore keeps tasks in memory. Rather than rewriting its JSON file
+// after every change, it appends the change as an event to a journal
+// next to the file; at startup the file is read as a snapshot and the
+// journal replayed on top of it. Compact folds the journal back into
+// the file.
+type FileStore struct {
+	MemoryStore
+	path string
+	seq  int
+	// pending are the events not yet in the journal: those of the
+	// current change, or of the whole batch within Atomic
+	pending []Event
+	batch   bool
+}
+
+// NewFileStore loads the tasks in path and its journal. A missing file
+// is not an error: the task list simply starts empty.
+func NewFileStore(path string) (*FileStore, error) {
+	f := &FileStore{MemoryStore: *NewMemoryStore(), path: path}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// journalPath returns the path of the journal of the tasks file at path
+func journalPath(path string) string {
+	return path + ".journal"
+}
+
+// load reads the snapshot and replays the journal events it does not
+// include yet
+func (f *FileStore) load() error {
+	file, err := readSnapshot(f.path)
+	if err != nil {
+		return err
+	}
+	f.tasks = file.Tasks
+	sort.Slice(f.tasks, func(i, j int) bool { return f.tasks[i].ID < f.tasks[j].ID })
+	f.nextID = file.NextID
+	f.seq = file.JournalSeq
+
+	events, size, err := readJournal(journalPath(f.path))
+	if err != nil {
+		return err
+	}
+	if err := truncateJournal(journalPath(f.path), size); err != nil {
+		return err
+	}
+	for _, event := range events {
+		if event.Seq <= file.JournalSeq {
+			continue
+		}
+		if err := f.replay(event); err != nil {
+			return fmt.Errorf("%s: event %d: %v", journalPath(f.path), event.Seq, err)
+		}
+		f.seq = event.Seq
+	}
+
+	for _, task := range f.tasks {
+		if task.ID >= f.nextID {
+			f.nextID = task.ID + 1
+		}
+	}
+	if f.nextID < 1 {
+		f.nextID = 1
+	}
+	return nil
+}
+
+// replay applies a journal event to the tasks in memory. Events set the
+// task to the state they record, so replaying one twice does no harm.
+func (f *FileStore) replay(event Event) error {
+	if event.Task == nil {
+		if err := f.MemoryStore.Delete(event.ID); err != nil && err != errTaskNotFound {
+			return err
+		}
+		return nil
+	}
+	task := snapshot(event.Task)
+	if f.find(task.ID) >= 0 {
+		return f.MemoryStore.Update(task)
+	}
+	return f.MemoryStore.Create(task)
+}
+
+// Create adds a task and records it in the journal. If the journal
+// cannot be written, the task is not added.
+func (f *FileStore) Create(task *Task) error {
+	id, nextID := task.ID, f.nextID
+	if err := f.MemoryStore.Create(task); err != nil {
+		return err
+	}
+	f.record(nil, task)
+	if err := f.save(); err != nil {
+		f.MemoryStore.Delete(task.ID)
+		task.ID, f.nextID = id, nextID
+		return err
+	}
+	return nil
+}
+
+// Update replaces a task and records the change in the journal. If the
+// journal cannot be written, the task keeps its old state.
+func (f *FileStore) Update(task *Task) error {
+	before, err := f.MemoryStore.Get(task.ID)
+	if err != nil {
+		return err
+	}
+	if err := f.MemoryStore.Update(task); err != nil {
+		return err
+	}
+	f.record(before, task)
+	if err := f.save(); err != nil {
+		f.MemoryStore.Update(before)
+		return err
+	}
+	return nil
+}
+
+// Delete removes a task and records it in the journal. If the journal
+// cannot be written, the task stays.
+func (f *FileStore) Delete(id int) error {
+	before, err := f.MemoryStore.Get(id)
+	if err != nil {
+		return err
+	}
+	if err := f.MemoryStore.Delete(id); err != nil {
+		return err
+	}
+	f.record(before, nil)
+	if err := f.save(); err != nil {
+		f.MemoryStore.Create(before)
+		return err
+	}
+	return nil
+}
+
+// record queues the event for a change until the next save
+func (f *FileStore) record(before, after *Task) {
+	event := newEvent(before, after)
+	f.seq++
+	event.Seq = f.seq
+	f.pending = append(f.pending, event)
+}
+
+// Atomic runs fn on the store and writes its events to the journal at
+// once when it succeeds. If fn or the write fails, the tasks are put
+// back as they were.
+func (f *FileStore) Atomic(fn func(TaskStore) error) error {
+	if f.batch {
+		return fn(f)
+	}
+	f.batch = true
+	seq := f.seq
+	defer func() { f.batch = false }()
+	err := f.MemoryStore.Atomic(func(TaskStore) error {
+		if err := fn(f); err != nil {
+			return err
+		}
+		f.batch = false
+		return f.save()
+	})
+	if err != nil {
+		f.pending = nil
+		f.seq = seq
+	}
+	return err
+}
+
+// save appends the pending events to the journal. Within Atomic it
+// waits for the end of the batch. If the append fails, the events are
+// dropped and their sequence numbers used again; the caller puts the
+// tasks back.
+func (f *FileStore) save() error {
+	if f.batch || len(f.pending) == 0 {
+		return nil
+	}
+	events := f.pending
+	f.pending = nil
+	if err := appendJournal(journalPath(f.path), events); err != nil {
+		f.seq = events[0].Seq - 1
+		return err
+	}
+	return nil
+}
+
+// Events returns the changes to task id recorded in the journal, oldest
+// first. A task that is in the snapshot starts with an eventSnapshot of
+// its state when the journal was last compacted.
+func (f *FileStore) Events(id int) ([]Event, error) {
+	file, err := readSnapshot(f.path)
+	if err != nil {
+		return nil, err
+	}
+	journal, _, err := readJournal(journalPath(f.path))
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	for i := range file.Tasks {
+		if file.Tasks[i].ID != id {
+			continue
+		}
+		event := Event{Type: eventSnapshot, ID: id, Task: &file.Tasks[i]}
+		if file.CompactedAt != nil {
+			event.Time = *file.CompactedAt
+		}
+		events = append(events, event)
+	}
+	for _, event := range taskEvents(journal, id) {
+		if event.Seq > file.JournalSeq {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// Compact writes the tasks to the snapshot file and empties the journal,
+// and returns the number of events folded into the snapshot. The tasks
+// are read again first, so that changes another process added to the
+// journal since this store loaded it are kept.
+func (f *FileStore) Compact() (int, error) {
+	events, _, err := readJournal(journalPath(f.path))
+	if err != nil {
+		return 0, err
+	}
+	if err := f.load(); err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	if err := f.writeSnapshot(&now); err != nil {
+		return 0, err
+	}
+	// The snapshot records the last event it includes, so should the
+	// journal survive a crash here its events are not applied twice
+	if err := os.Remove(journalPath(f.path)); err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("error removing %s: %v", journalPath(f.path), err)
+	}
+	return len(events), nil
+}
+
+// writeSnapshot writes tasks, the ID counter and the position in the
+// journal to the store's file
+func (f *FileStore) writeSnapshot(compactedAt *time.Time) error {
+	file := taskFile{NextID: f.nextID, Tasks: f.tasks, JournalSeq: f.seq, CompactedAt: compactedAt}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding tasks: %v", err)
+	}
+	if err := replaceFile(f.path, data); err != nil {
+		return fmt.Errorf("error saving tasks: %v", err)
+	}
+	return nil
+}
+
+// replaceFile writes data to path. The data goes to a temporary file
+// first and is then renamed over it, so a crash mid-write never leaves
+// a truncated file behind.
+func replaceFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// fileProjects keeps every project but the default one in a JSON file of
+// its own next to the default file: project "work" of tasks.json is kept
+// in tasks.work.json
+type fileProjects struct {
+	path string
+}
+
+// projectPath returns the file of project name
+func (p fileProjects) projectPath(name string) string {
+	if name == defaultProject {
+		return p.path
+	}
+	base, ext := p.split()
+	return base + "." + name + ext
+}
+
+// split returns the default file's path without and with its extension,
+// which is .json when it has none
+func (p fileProjects) split() (base, ext string) {
+	ext = filepath.Ext(p.path)
+	base = strings.TrimSuffix(p.path, ext)
+	if ext == "" {
+		ext = ".json"
+	}
+	return base, ext
+}
+
+// ListProjects returns the default project and every project that has a
+// file, in alphabetical order
+func (p fileProjects) ListProjects() ([]string, error) {
+	base, ext := p.split()
+	matches, err := filepath.Glob(base + ".*" + ext)
+	if err != nil {
+		return nil, fmt.Errorf("error listing projects: %v", err)
+	}
+	names := []string{defaultProject}
+	for _, match := range matches {
+		name := strings.TrimSuffix(strings.TrimPrefix(match, base+"."), ext)
+		if name != defaultProject && validateProjectName(name) == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// OpenProject loads the tasks of an existing project
+func (p fileProjects) OpenProject(name string) (TaskStore, error) {
+	path := p.projectPath(name)
+	if name != defaultProject {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil, errProjectNotFound
+		}
+	}
+	return NewFileStore(path)
+}
+
+// CreateProject writes an empty file for a new project
+func (p fileProjects) CreateProject(name string) error {
+	path := p.projectPath(name)
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("project %q already exists", name)
+	}
+	f, err := NewFileStore(path)
+	if err != nil {
+		return err
+	}
+	return f.writeSnapshot(nil)
+}
+
+// RenameProject renames a project's file and its journal
+func (p fileProjects) RenameProject(oldName, newName string) error {
+	oldPath, newPath := p.projectPath(oldName), p.projectPath(newName)
+	if _, err := os.Stat(oldPath); os.IsNotExist(err) {
+		return errProjectNotFound
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return fmt.Errorf("error renaming project: %v", err)
+	}
+	// A journal left behind under the old name would split the project,
+	// so the snapshot goes back when the journal cannot follow it
+	if err := os.Rename(journalPath(oldPath), journalPath(newPath)); err != nil && !os.IsNotExist(err) {
+		os.Rename(newPath, oldPath)
+		return fmt.Errorf("error renaming project: %v", err)
+	}
+	return nil
+}
+
+// DeleteProject removes a project's file and its journal
+func (p fileProjects) DeleteProject(name string) error {
+	path := p.projectPath(name)
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return errProjectNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("error deleting project: %v", err)
+	}
+	if err := os.Remove(journalPath(path)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error deleting project: %v", err)
+	}
+	return nil
+}
+
+// Close does nothing; the project files are closed after every write
+func (p fileProjects) Close() error {
+	return nil
+}
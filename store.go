@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// errTaskNotFound is returned when no task has the requested ID
+var errTaskNotFound = errors.New("task not found")
+
+// TaskStore keeps tasks. Implementations must behave the same way:
+//
+//   - Create assigns the next free ID to a task whose ID is 0 and keeps
+//     any other ID, failing if it is taken. IDs are never reused.
+//   - Get, Update and Delete return errTaskNotFound for unknown IDs.
+//   - Tasks passed in or returned are copies; changing them does not
+//     change the store.
+//   - Atomic calls fn with a store whose changes are kept together: if
+//     fn returns an error, none of them are.
+type TaskStore interface {
+	Create(task *Task) error
+	Get(id int) (*Task, error)
+	Update(task *Task) error
+	Delete(id int) error
+	List(opts ListOptions) ([]Task, error)
+	Atomic(fn func(TaskStore) error) error
+	Close() error
+}
+
+// ListOptions selects and orders the tasks returned by TaskStore.List.
+// The zero value lists every task that is neither archived nor in the
+// trash, ordered by ID.
+type ListOptions struct {
+	Filter   *Filter   // only tasks matching the filter; nil matches all
+	Sort     string    // an order accepted by sortTasks
+	Limit    int       // at most this many tasks; 0 means no limit
+	Now      time.Time // the time overdue is judged against; zero means now
+	Archived bool      // also list archived tasks
+	Trashed  bool      // also list tasks in the trash
+}
+
+// apply filters, sorts and limits tasks, which must be ordered by ID
+func (opts ListOptions) apply(tasks []Task) ([]Task, error) {
+	if !opts.Archived || !opts.Trashed {
+		kept := tasks[:0]
+		for _, task := range tasks {
+			if (task.ArchivedAt == nil || opts.Archived) && (task.DeletedAt == nil || opts.Trashed) {
+				kept = append(kept, task)
+			}
+		}
+		tasks = kept
+	}
+	if err := sortTasks(tasks, opts.Sort); err != nil {
+		return nil, err
+	}
+	if opts.Filter != nil {
+		now := opts.Now
+		if now.IsZero() {
+			now = time.Now()
+		}
+		tasks = opts.Filter.Apply(tasks, now)
+	}
+	if opts.Sort == sortDeps {
+		tasks = topoOrder(tasks)
+	}
+	if opts.Limit > 0 && len(tasks) > opts.Limit {
+		tasks = tasks[:opts.Limit]
+	}
+	return tasks, nil
+}
+
+// MemoryStore keeps tasks in memory only. It is the base of FileStore
+// and is handy in tests.
+type MemoryStore struct {
+	tasks  []Task
+	nextID int
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{nextID: 1}
+}
+
+// find returns the index of task id, or -1
+func (m *MemoryStore) find(id int) int {
+	i := sort.Search(len(m.tasks), func(i int) bool { return m.tasks[i].ID >= id })
+	if i < len(m.tasks) && m.tasks[i].ID == id {
+		return i
+	}
+	return -1
+}
+
+// Create adds a task, assigning its ID when it has none
+func (m *MemoryStore) Create(task *Task) error {
+	if task.ID == 0 {
+		task.ID = m.nextID
+	}
+	if m.find(task.ID) >= 0 {
+		return fmt.Errorf("task %d already exists", task.ID)
+	}
+	m.tasks = append(m.tasks, *snapshot(task))
+	sort.Slice(m.tasks, func(i, j int) bool { return m.tasks[i].ID < m.tasks[j].ID })
+	if task.ID >= m.nextID {
+		m.nextID = task.ID + 1
+	}
+	return nil
+}
+
+// Get returns a copy of task id
+func (m *MemoryStore) Get(id int) (*Task, error) {
+	i := m.find(id)
+	if i < 0 {
+		return nil, errTaskNotFound
+	}
+	return snapshot(&m.tasks[i]), nil
+}
+
+// Update replaces the task with the same ID
+func (m *MemoryStore) Update(task *Task) error {
+	i := m.find(task.ID)
+	if i < 0 {
+		return errTaskNotFound
+	}
+	m.tasks[i] = *snapshot(task)
+	return nil
+}
+
+// Delete removes task id
+func (m *MemoryStore) Delete(id int) error {
+	i := m.find(id)
+	if i < 0 {
+		return errTaskNotFound
+	}
+	m.tasks = append(m.tasks[:i], m.tasks[i+1:]...)
+	return nil
+}
+
+// List returns copies of the tasks selected by opts
+func (m *MemoryStore) List(opts ListOptions) ([]Task, error) {
+	tasks := make([]Task, 0, len(m.tasks))
+	for i := range m.tasks {
+		tasks = append(tasks, *snapshot(&m.tasks[i]))
+	}
+	return opts.apply(tasks)
+}
+
+// Atomic runs fn on the store and puts the tasks back as they were if
+// it fails
+func (m *MemoryStore) Atomic(fn func(TaskStore) error) error {
+	tasks := make([]Task, len(m.tasks))
+	for i := range m.tasks {
+		tasks[i] = *snapshot(&m.tasks[i])
+	}
+	nextID := m.nextID
+	if err := fn(m); err != nil {
+		m.tasks, m.nextID = tasks, nextID
+		return err
+	}
+	return nil
+}
+
+// Close does nothing; it is there to satisfy TaskStore
+func (m *MemoryStore) Close() error {
+	return nil
+}
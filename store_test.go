@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMemoryStore runs the store conformance tests against MemoryStore
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) TaskStore {
+		return NewMemoryStore()
+	})
+}
+
+// TestFileStore runs the store conformance tests against FileStore
+func TestFileStore(t *testing.T) {
+	testStore(t, func(t *testing.T) TaskStore {
+		store, err := NewFileStore(filepath.Join(t.TempDir(), "tasks.json"))
+		if err != nil {
+			t.Fatalf("NewFileStore() returned error: %v", err)
+		}
+		return store
+	})
+}
+
+// TestDatabaseStore runs the store conformance tests against Database
+func TestDatabaseStore(t *testing.T) {
+	testStore(t, func(t *testing.T) TaskStore {
+		db, err := NewDatabase(filepath.Join(t.TempDir(), "tasks.db"))
+		if err != nil {
+			t.Fatalf("NewDatabase() returned error: %v", err)
+		}
+		if err := db.InitSchema(); err != nil {
+			t.Fatalf("InitSchema() returned error: %v", err)
+		}
+		return db
+	})
+}
+
+// TestDatabaseConcurrentWriters tests that connections creating tasks
+// at the same time, as separate processes do, wait for each other
+// instead of failing or handing out the same ID
+func TestDatabaseConcurrentWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.db")
+	var dbs []*Database
+	for i := 0; i < 4; i++ {
+		db, err := NewDatabase(path)
+		if err != nil {
+			t.Fatalf("NewDatabase() returned error: %v", err)
+		}
+		defer db.Close()
+		if err := db.InitSchema(); err != nil {
+			t.Fatalf("InitSchema() returned error: %v", err)
+		}
+		dbs = append(dbs, db)
+	}
+
+	const perWriter = 50
+	errs := make(chan error, len(dbs)*perWriter)
+	var wg sync.WaitGroup
+	for w, db := range dbs {
+		wg.Add(1)
+		go func(w int, db *Database) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				errs <- db.Create(&Task{Title: fmt.Sprintf("Writer %d task %d", w, i)})
+			}
+		}(w, db)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Create() returned error: %v", err)
+		}
+	}
+
+	tasks, err := dbs[0].List(ListOptions{})
+	if err != nil {
+		t.Fatalf("List() returned error: %v", err)
+	}
+	if len(tasks) != len(dbs)*perWriter {
+		t.Errorf("List() returned %d tasks; want %d", len(tasks), len(dbs)*perWriter)
+	}
+}
+
+// TestFileStoreReload tests that a FileStore reads back what it saved
+func TestFileStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.json")
+	first, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() returned error: %v", err)
+	}
+	for _, title := range []string{"One", "Two"} {
+		if err := first.Create(&Task{Title: title}); err != nil {
+			t.Fatalf("Create() returned error: %v", err)
+		}
+	}
+	if err := first.Delete(2); err != nil {
+		t.Fatalf("Delete() returned error: %v", err)
+	}
+
+	second, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() returned error: %v", err)
+	}
+	task := &Task{Title: "Three"}
+	if err := second.Create(task); err != nil {
+		t.Fatalf("Create() returned error: %v", err)
+	}
+	if task.ID != 3 {
+		t.Errorf("ID after reload = %d; want 3", task.ID)
+	}
+	if got, err := second.Get(1); err != nil || got.Title != "One" {
+		t.Errorf("Get(1) = %v, %v; want task One", got, err)
+	}
+}
+
+// testStore checks the behaviour every TaskStore must share. newStore
+// returns an empty store.
+func testStore(t *testing.T, newStore func(t *testing.T) TaskStore) {
+	due := time.Date(2024, 5, 1, 17, 0, 0, 0, time.Local)
+	worked := due.Add(-time.Hour)
+	rule, _ := ParseRecurrence("weekly:mon")
+
+	t.Run("create assigns increasing IDs", func(t *testing.T) {
+		store := newStore(t)
+		defer store.Close()
+		for want := 1; want <= 3; want++ {
+			task := &Task{Title: "Task"}
+			if err := store.Create(task); err != nil {
+				t.Fatalf("Create() returned error: %v", err)
+			}
+			if task.ID != want {
+				t.Errorf("Create() assigned ID %d; want %d", task.ID, want)
+			}
+		}
+	})
+
+	t.Run("IDs are not reused", func(t *testing.T) {
+		store := newStore(t)
+		defer store.Close()
+		store.Create(&Task{Title: "One"})
+		store.Create(&Task{Title: "Two"})
+		if err := store.Delete(2); err != nil {
+			t.Fatalf("Delete() returned error: %v", err)
+		}
+		task := &Task{Title: "Three"}
+		if err := store.Create(task); err != nil {
+			t.Fatalf("Create() returned error: %v", err)
+		}
+		if task.ID != 3 {
+			t.Errorf("Create() assigned ID %d; want 3", task.ID)
+		}
+	})
+
+	t.Run("create keeps an explicit ID", func(t *testing.T) {
+		store := newStore(t)
+		defer store.Close()
+		if err := store.Create(&Task{ID: 7, Title: "Seven"}); err != nil {
+			t.Fatalf("Create() returned error: %v", err)
+		}
+		if err := store.Create(&Task{ID: 7, Title: "Again"}); err == nil {
+			t.Errorf("Create() with a taken ID returned no error")
+		}
+		task := &Task{Title: "Next"}
+		if err := store.Create(task); err != nil {
+			t.Fatalf("Create() returned error: %v", err)
+		}
+		if task.ID != 8 {
+			t.Errorf("Create() assigned ID %d; want 8", task.ID)
+		}
+	})
+
+	t.Run("round trip keeps every field", func(t *testing.T) {
+		store := newStore(t)
+		defer store.Close()
+		store.Create(&Task{Title: "Parent"})
+		want := Task{
+			Title:       "Child",
+			Description: "Line one\nLine two",
+			Completed:   true,
+			Priority:    PriorityHigh,
+			DueDate:     &due,
+			Tags:        []string{"home", "work"},
+			ParentID:    1,
+			Recurrence:  rule,
+			TimeEntries: []TimeEntry{{Start: worked.Add(-time.Hour), End: &worked}, {Start: due}},
+			ArchivedAt:  &due,
+			Extra:       []string{"key:value", "ref:42"},
+		}
+		task := want
+		if err := store.Create(&task); err != nil {
+			t.Fatalf("Create() returned error: %v", err)
+		}
+		want.ID = task.ID
+
+		got, err := store.Get(task.ID)
+		if err != nil {
+			t.Fatalf("Get() returned error: %v", err)
+		}
+		if !sameTask(got, &want) {
+			t.Errorf("Get() = %+v; want %+v", *got, want)
+		}
+	})
+
+	t.Run("update replaces the task", func(t *testing.T) {
+		store := newStore(t)
+		defer store.Close()
+		task := &Task{Title: "Draft", Tags: []string{"old"}}
+		store.Create(task)
+		task.Title = "Final"
+		task.Tags = nil
+		task.DueDate = &due
+		if err := store.Update(task); err != nil {
+			t.Fatalf("Update() returned error: %v", err)
+		}
+		got, err := store.Get(task.ID)
+		if err != nil {
+			t.Fatalf("Get() returned error: %v", err)
+		}
+		if got.Title != "Final" || len(got.Tags) != 0 || got.DueDate == nil || !got.DueDate.Equal(due) {
+			t.Errorf("Get() after Update() = %+v", *got)
+		}
+	})
+
+	t.Run("returned tasks are copies", func(t *testing.T) {
+		store := newStore(t)
+		defer store.Close()
+		store.Create(&Task{Title: "Original", Tags: []string{"a"}})
+		got, _ := store.Get(1)
+		got.Title = "Changed"
+		got.Tags[0] = "b"
+		again, _ := store.Get(1)
+		if again.Title != "Original" || again.Tags[0] != "a" {
+			t.Errorf("changing a returned task changed the store: %+v", *again)
+		}
+	})
+
+	t.Run("unknown IDs are not found", func(t *testing.T) {
+		store := newStore(t)
+		defer store.Close()
+		if _, err := store.Get(42); err != errTaskNotFound {
+			t.Errorf("Get() error = %v; want %v", err, errTaskNotFound)
+		}
+		if err := store.Update(&Task{ID: 42, Title: "Ghost"}); err != errTaskNotFound {
+			t.Errorf("Update() error = %v; want %v", err, errTaskNotFound)
+		}
+		if err := store.Delete(42); err != errTaskNotFound {
+			t.Errorf("Delete() error = %v; want %v", err, errTaskNotFound)
+		}
+	})
+
+	t.Run("list applies options", func(t *testing.T) {
+		store := newStore(t)
+		defer store.Close()
+		store.Create(&Task{Title: "Low", Priority: PriorityLow, Tags: []string{"work"}})
+		store.Create(&Task{Title: "Urgent", Priority: PriorityUrgent, Tags: []string{"work"}})
+		store.Create(&Task{Title: "Home", Priority: PriorityHigh, Tags: []string{"home"}})
+		store.Create(&Task{Title: "Old", Completed: true, Tags: []string{"work"}, ArchivedAt: &due})
+		store.Create(&Task{Title: "Deleted", DeletedAt: &due})
+		work, _ := ParseFilter("tag:work")
+
+		tests := []struct {
+			name string
+			opts ListOptions
+			want []int
+		}{
+			{"all", ListOptions{}, []int{1, 2, 3}},
+			{"sorted", ListOptions{Sort: "priority"}, []int{2, 3, 1}},
+			{"filtered", ListOptions{Filter: work, Sort: "priority"}, []int{2, 1}},
+			{"limited", ListOptions{Sort: "priority", Limit: 2}, []int{2, 3}},
+			{"archived", ListOptions{Filter: work, Archived: true}, []int{1, 2, 4}},
+			{"trashed", ListOptions{Trashed: true}, []int{1, 2, 3, 5}},
+			{"everything", ListOptions{Archived: true, Trashed: true}, []int{1, 2, 3, 4, 5}},
+		}
+		for _, tt := range tests {
+			tasks, err := store.List(tt.opts)
+			if err != nil {
+				t.Fatalf("%s: List() returned error: %v", tt.name, err)
+			}
+			var got []int
+			for _, task := range tasks {
+				got = append(got, task.ID)
+			}
+			if !equalIDs(got, tt.want) {
+				t.Errorf("%s: List() returned IDs %v; want %v", tt.name, got, tt.want)
+			}
+		}
+
+		if _, err := store.List(ListOptions{Sort: "colour"}); err == nil {
+			t.Errorf("List() with an unknown sort order returned no error")
+		}
+	})
+	t.Run("atomic keeps all changes or none", func(t *testing.T) {
+		store := newStore(t)
+		defer store.Close()
+		store.Create(&Task{Title: "One"})
+		store.Create(&Task{Title: "Two"})
+		failed := fmt.Errorf("failed")
+		err := store.Atomic(func(tx TaskStore) error {
+			tx.Update(&Task{ID: 1, Title: "Changed"})
+			tx.Delete(2)
+			tx.Create(&Task{Title: "Three"})
+			return failed
+		})
+		if err != failed {
+			t.Fatalf("Atomic() error = %v; want %v", err, failed)
+		}
+		tasks, _ := store.List(ListOptions{})
+		if len(tasks) != 2 || tasks[0].Title != "One" || tasks[1].Title != "Two" {
+			t.Errorf("tasks after a failed Atomic() = %+v; want One and Two", tasks)
+		}
+
+		err = store.Atomic(func(tx TaskStore) error {
+			if err := tx.Update(&Task{ID: 1, Title: "Changed"}); err != nil {
+				return err
+			}
+			task := &Task{Title: "Three"}
+			if err := tx.Create(task); err != nil {
+				return err
+			}
+			if task.ID != 3 {
+				t.Errorf("Create() in Atomic() assigned ID %d; want 3", task.ID)
+			}
+			return tx.Delete(2)
+		})
+		if err != nil {
+			t.Fatalf("Atomic() returned error: %v", err)
+		}
+		tasks, _ = store.List(ListOptions{})
+		if len(tasks) != 2 || tasks[0].Title != "Changed" || tasks[1].Title != "Three" {
+			t.Errorf("tasks after Atomic() = %+v; want Changed and Three", tasks)
+		}
+	})
+}
+
+// useStore makes s the store of the current project for the rest of the
+// test, as openStorage does
+func useStore(t *testing.T, s TaskStore) {
+	t.Helper()
+	savedStore, savedProject := store, currentProject
+	store, currentProject = s, defaultProject
+	openStores[defaultProject] = s
+	t.Cleanup(func() {
+		store, currentProject = savedStore, savedProject
+		delete(openStores, defaultProject)
+	})
+}
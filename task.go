@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Task is a single item in the task list
+type Task struct {
+	ID          int         `json:"id"`
+	Title       string      `json:"title"`
+	Description string      `json:"description"`
+	Completed   bool        `json:"completed"`
+	Priority    Priority    `json:"priority"`
+	DueDate     *time.Time  `json:"due_date,omitempty"`
+	Tags        []string    `json:"tags,omitempty"`
+	ParentID    int         `json:"parent_id,omitempty"`
+	Recurrence  *Recurrence `json:"recurrence,omitempty"`
+	TimeEntries []TimeEntry `json:"time_entries,omitempty"`
+	BlockedBy   []int       `json:"blocked_by,omitempty"`
+	CreatedAt   *time.Time  `json:"created_at,omitempty"`
+	CompletedAt *time.Time  `json:"completed_at,omitempty"`
+	ArchivedAt  *time.Time  `json:"archived_at,omitempty"`
+	DeletedAt   *time.Time  `json:"deleted_at,omitempty"`
+	// State is where the task is in the workflow; Completed is set
+	// whenever it is one of the done states. Tasks from before workflows
+	// have none, see Workflow.StateOf.
+	State string `json:"state,omitempty"`
+	// Extra holds the todo.txt tokens the task has no field for, and how
+	// the file spelled its priority and tags, so that exporting the task
+	// writes them back
+	Extra []string `json:"extra,omitempty"`
+}
+
+// IsOverdue reports whether an open task is past its due date
+func (t Task) IsOverdue(now time.Time) bool {
+	return !t.Completed && t.DueDate != nil && now.After(*t.DueDate)
+}
+
+// fieldChange is one field that differs between two versions of a task
+type fieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+func (c fieldChange) String() string {
+	return fmt.Sprintf("%s: %q -> %q", c.Field, c.Old, c.New)
+}
+
+// diffTasks lists the fields that differ between old and new
+func diffTasks(old, new Task) []fieldChange {
+	var changes []fieldChange
+	compare := func(field, a, b string) {
+		if a != b {
+			changes = append(changes, fieldChange{Field: field, Old: a, New: b})
+		}
+	}
+	compare("title", old.Title, new.Title)
+	compare("description", old.Description, new.Description)
+	compare("completed", fmt.Sprint(old.Completed), fmt.Sprint(new.Completed))
+	compare("state", workflow.StateOf(old), workflow.StateOf(new))
+	compare("priority", old.Priority.String(), new.Priority.String())
+	compare("due", formatDueDate(old.DueDate), formatDueDate(new.DueDate))
+	compare("tags", strings.Join(old.Tags, ","), strings.Join(new.Tags, ","))
+	compare("parent", formatParent(old.ParentID), formatParent(new.ParentID))
+	compare("repeat", formatRecurrence(old.Recurrence), formatRecurrence(new.Recurrence))
+	compare("blocked by", formatIDs(old.BlockedBy), formatIDs(new.BlockedBy))
+	return changes
+}
+
+// formatParent renders a parent ID, or "-" for top-level tasks
+func formatParent(id int) string {
+	if id == 0 {
+		return "-"
+	}
+	return fmt.Sprint(id)
+}
+
+// formatRecurrence renders a recurrence rule, or "-" for none
+func formatRecurrence(r *Recurrence) string {
+	if r == nil {
+		return "-"
+	}
+	return r.String()
+}
+
+// HasTag reports whether the task carries tag
+func (t Task) HasTag(tag string) bool {
+	tag = normalizeTag(tag)
+	for _, existing := range t.Tags {
+		if existing == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// AddTags adds tags to the task, ignoring ones it already has
+func (t *Task) AddTags(tags ...string) {
+	t.Tags = normalizeTags(append(t.Tags, tags...))
+}
+
+// RemoveTags removes tags from the task
+func (t *Task) RemoveTags(tags ...string) {
+	remove := map[string]bool{}
+	for _, tag := range tags {
+		remove[normalizeTag(tag)] = true
+	}
+	var kept []string
+	for _, tag := range t.Tags {
+		if !remove[tag] {
+			kept = append(kept, tag)
+		}
+	}
+	t.Tags = kept
+}
+
+// normalizeTag lowercases a tag and strips a leading '#'
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+}
+
+// normalizeTags returns tags normalized, sorted and without duplicates
+func normalizeTags(tags []string) []string {
+	seen := map[string]bool{}
+	var result []string
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// parseTagList splits a list of tags separated by commas or spaces
+func parseTagList(s string) []string {
+	return normalizeTags(strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	}))
+}
+
+// Priority ranks how urgent a task is
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityMedium
+	PriorityHigh
+	PriorityUrgent
+)
+
+var priorityNames = []string{"low", "medium", "high", "urgent"}
+
+func (p Priority) String() string {
+	if p < PriorityLow || p > PriorityUrgent {
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+	return priorityNames[p]
+}
+
+// ParsePriority accepts a priority name or its first letter
+func ParsePriority(s string) (Priority, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for i, name := range priorityNames {
+		if s == name || (len(s) == 1 && s[0] == name[0]) {
+			return Priority(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority %q (use low, medium, high or urgent)", s)
+}
+
+// MarshalText stores priorities by name in JSON
+func (p Priority) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText reads a priority name
+func (p *Priority) UnmarshalText(text []byte) error {
+	parsed, err := ParsePriority(string(text))
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// parseDueDate parses a due date in words or digits, relative to now in
+// local time; see parseDate
+func parseDueDate(s string) (time.Time, error) {
+	return parseDate(s, time.Now())
+}
+
+// formatDueDate renders a due date the way parseDueDate accepts it
+func formatDueDate(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format("2006-01-02 15:04")
+}
+
+// sortDeps orders tasks after the tasks blocking them; see topoOrder
+const sortDeps = "deps"
+
+// sortKeys are the orders sortTasks understands
+var sortKeys = []string{"id", "priority", "due", sortDeps}
+
+// sortTasks orders tasks in place by "id", "priority" (most urgent
+// first) or "due" (soonest first, tasks without a due date last)
+func sortTasks(tasks []Task, by string) error {
+	less, err := sortOrder(by)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(tasks, func(i, j int) bool { return less(tasks[i], tasks[j]) })
+	return nil
+}
+
+// sortOrder returns the comparison sortTasks uses for by, or an error
+// for an unknown order
+func sortOrder(by string) (func(a, b Task) bool, error) {
+	switch by {
+	case "", "id", sortDeps:
+		// Dependency order starts from ID order, which ListOptions.apply
+		// then rearranges with topoOrder
+		return func(a, b Task) bool { return a.ID < b.ID }, nil
+	case "priority":
+		return func(a, b Task) bool {
+			if a.Priority != b.Priority {
+				return a.Priority > b.Priority
+			}
+			if c := compareDue(a, b); c != 0 {
+				return c < 0
+			}
+			return a.ID < b.ID
+		}, nil
+	case "due":
+		return func(a, b Task) bool {
+			if c := compareDue(a, b); c != 0 {
+				return c < 0
+			}
+			if a.Priority != b.Priority {
+				return a.Priority > b.Priority
+			}
+			return a.ID < b.ID
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown sort order %q (use %s)", by, strings.Join(sortKeys, ", "))
+}
+
+// compareDue orders tasks by due date with undated tasks last
+func compareDue(a, b Task) int {
+	switch {
+	case a.DueDate == nil && b.DueDate == nil:
+		return 0
+	case a.DueDate == nil:
+		return 1
+	case b.DueDate == nil:
+		return -1
+	case a.DueDate.Before(*b.DueDate):
+		return -1
+	case a.DueDate.After(*b.DueDate):
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// TimeEntry is a stretch of time worked on a task. The entry of a
+// running timer has no End yet.
+type TimeEntry struct {
+	Start time.Time  `json:"start"`
+	End   *time.Time `json:"end,omitempty"`
+}
+
+// Running reports whether the task's timer is running
+func (t Task) Running() bool {
+	n := len(t.TimeEntries)
+	return n > 0 && t.TimeEntries[n-1].End == nil
+}
+
+// Tracked returns the time tracked on the task, counting a running timer
+// up to now
+func (t Task) Tracked(now time.Time) time.Duration {
+	return t.TrackedBetween(time.Time{}, now, now)
+}
+
+// TrackedBetween returns the time tracked on the task between from and
+// to, counting a running timer up to now
+func (t Task) TrackedBetween(from, to, now time.Time) time.Duration {
+	var total time.Duration
+	for _, entry := range t.TimeEntries {
+		start, end := entry.Start, now
+		if entry.End != nil {
+			end = *entry.End
+		}
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			total += end.Sub(start)
+		}
+	}
+	return total
+}
+
+// dayTotal is the time tracked on one calendar day
+type dayTotal struct {
+	Day     time.Time
+	Tracked time.Duration
+}
+
+// TrackedByDay splits the tracked time by local calendar day, oldest
+// first. An entry that runs past midnight counts towards both days.
+func (t Task) TrackedByDay(now time.Time) []dayTotal {
+	if len(t.TimeEntries) == 0 {
+		return nil
+	}
+	first := startOfDay(t.TimeEntries[0].Start)
+	var days []dayTotal
+	for day := first; day.Before(now); day = day.AddDate(0, 0, 1) {
+		if tracked := t.TrackedBetween(day, day.AddDate(0, 0, 1), now); tracked > 0 {
+			days = append(days, dayTotal{Day: day, Tracked: tracked})
+		}
+	}
+	return days
+}
+
+// startOfDay returns local midnight at the start of t's day
+func startOfDay(t time.Time) time.Time {
+	t = t.Local()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// formatTracked renders tracked time in hours and minutes, e.g. "3h 05m"
+func formatTracked(d time.Duration) string {
+	d = d.Round(time.Minute)
+	return fmt.Sprintf("%dh %02dm", d/time.Hour, d%time.Hour/time.Minute)
+}
+
+// runningTask returns the task whose timer is running and its project,
+// or a nil task. Only one timer runs across all projects. Archived and
+// trashed tasks are looked at too, so a timer left running on one can
+// still be stopped.
+func runningTask() (*Task, string, error) {
+	names, err := projects.ListProjects()
+	if err != nil {
+		return nil, "", err
+	}
+	for _, name := range names {
+		s, err := projectStore(name)
+		if err != nil {
+			return nil, "", err
+		}
+		tasks, err := s.List(ListOptions{Archived: true, Trashed: true})
+		if err != nil {
+			return nil, "", err
+		}
+		for i := range tasks {
+			if tasks[i].Running() {
+				return &tasks[i], name, nil
+			}
+		}
+	}
+	return nil, "", nil
+}
+
+// describeTask names task id of project, mentioning the project only
+// when it is not the current one
+func describeTask(id int, project string) string {
+	if project == currentProject {
+		return fmt.Sprintf("task %d", id)
+	}
+	return fmt.Sprintf("task %d in project %s", id, project)
+}
+
+// startTimer starts tracking time on task id. Only one timer runs at a
+// time, so it fails while another task's timer is running.
+func startTimer(id int) (*Task, error) {
+	task, err := getTask(id)
+	if err != nil {
+		return nil, err
+	}
+	if task.Completed {
+		return nil, fmt.Errorf("task %d is already completed", id)
+	}
+	running, project, err := runningTask()
+	if err != nil {
+		return nil, err
+	}
+	if running != nil {
+		if running.ID == id && project == currentProject {
+			return nil, fmt.Errorf("the timer of task %d is already running", id)
+		}
+		return nil, fmt.Errorf("the timer of %s is running; stop it first", describeTask(running.ID, project))
+	}
+
+	task.TimeEntries = append(task.TimeEntries, TimeEntry{Start: time.Now()})
+	return task, updateTask(task)
+}
+
+// stopTimer stops the running timer. With id 0 it stops whichever timer
+// runs, in any project, otherwise only that of task id of the current
+// project.
+func stopTimer(id int) (*Task, error) {
+	running, project, err := runningTask()
+	if err != nil {
+		return nil, err
+	}
+	if id != 0 && (running == nil || running.ID != id || project != currentProject) {
+		if _, err := getTask(id); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("the timer of task %d is not running", id)
+	}
+	if running == nil {
+		return nil, fmt.Errorf("no timer is running")
+	}
+	stopEntry(running, time.Now())
+	return running, updateTaskIn(project, running)
+}
+
+// stopEntry ends the running entry of task at now
+func stopEntry(task *Task, now time.Time) {
+	entries := append([]TimeEntry(nil), task.TimeEntries...)
+	entries[len(entries)-1].End = &now
+	task.TimeEntries = entries
+}
+
+// timesheetRow is the time tracked on one task during a timesheet
+type timesheetRow struct {
+	ID      int           `json:"id"`
+	Title   string        `json:"title"`
+	Tracked time.Duration `json:"-"`
+	Minutes int           `json:"minutes"`
+}
+
+// timesheetDay is the time tracked on one day of a timesheet
+type timesheetDay struct {
+	Date    string         `json:"date"`
+	Tasks   []timesheetRow `json:"tasks"`
+	Tracked time.Duration  `json:"-"`
+	Minutes int            `json:"minutes"`
+}
+
+// Timesheet is the time tracked between two dates, by day and by task
+type Timesheet struct {
+	From    string         `json:"from"`
+	To      string         `json:"to"`
+	Days    []timesheetDay `json:"days"`
+	Tasks   []timesheetRow `json:"tasks"`
+	Tracked time.Duration  `json:"-"`
+	Minutes int            `json:"minutes"`
+}
+
+// trackedTasks returns the tasks of the current project a timesheet
+// sums up: archived and trashed ones too, as the time tracked on them
+// was still spent
+func trackedTasks() ([]Task, error) {
+	return store.List(ListOptions{Archived: true, Trashed: true})
+}
+
+// buildTimesheet sums the time tracked on tasks on the days from to to,
+// both included. Days without tracked time are left out.
+func buildTimesheet(tasks []Task, from, to, now time.Time) Timesheet {
+	sheet := Timesheet{From: from.Format("2006-01-02"), To: to.Format("2006-01-02"), Days: []timesheetDay{}, Tasks: []timesheetRow{}}
+	totals := map[int]*timesheetRow{}
+	for day := startOfDay(from); !day.After(to); day = day.AddDate(0, 0, 1) {
+		sheetDay := timesheetDay{Date: day.Format("2006-01-02")}
+		for _, task := range tasks {
+			tracked := task.TrackedBetween(day, day.AddDate(0, 0, 1), now)
+			if tracked <= 0 {
+				continue
+			}
+			sheetDay.Tasks = append(sheetDay.Tasks, newTimesheetRow(task, tracked))
+			sheetDay.Tracked += tracked
+			if totals[task.ID] == nil {
+				totals[task.ID] = &timesheetRow{ID: task.ID, Title: task.Title}
+			}
+			totals[task.ID].Tracked += tracked
+		}
+		if sheetDay.Tracked > 0 {
+			sheetDay.Minutes = minutes(sheetDay.Tracked)
+			sheet.Days = append(sheet.Days, sheetDay)
+			sheet.Tracked += sheetDay.Tracked
+		}
+	}
+	for _, row := range totals {
+		sheet.Tasks = append(sheet.Tasks, newTimesheetRow(Task{ID: row.ID, Title: row.Title}, row.Tracked))
+	}
+	sort.Slice(sheet.Tasks, func(i, j int) bool { return sheet.Tasks[i].ID < sheet.Tasks[j].ID })
+	sheet.Minutes = minutes(sheet.Tracked)
+	return sheet
+}
+
+// newTimesheetRow creates the row for tracked time on task
+func newTimesheetRow(task Task, tracked time.Duration) timesheetRow {
+	return timesheetRow{ID: task.ID, Title: task.Title, Tracked: tracked, Minutes: minutes(tracked)}
+}
+
+// minutes rounds d to whole minutes
+func minutes(d time.Duration) int {
+	return int(d.Round(time.Minute) / time.Minute)
+}
+
+// parseDay parses a YYYY-MM-DD date as local midnight
+func parseDay(s string) (time.Time, error) {
+	day, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q (use YYYY-MM-DD)", s)
+	}
+	return day, nil
+}
+
+// thisWeek returns Monday of the week containing now
+func thisWeek(now time.Time) time.Time {
+	today := startOfDay(now)
+	offset := (int(today.Weekday()) + 6) % 7
+	return today.AddDate(0, 0, -offset)
+}
+
+// printTimesheet writes the timesheet as a report by day and by task
+func printTimesheet(w io.Writer, sheet Timesheet) {
+	fmt.Fprintf(w, "Timesheet %s to %s\n", sheet.From, sheet.To)
+	if len(sheet.Days) == 0 {
+		fmt.Fprintln(w, "\nNo time tracked.")
+		return
+	}
+	for _, day := range sheet.Days {
+		date, _ := parseDay(day.Date)
+		fmt.Fprintf(w, "\n%s %s\n", day.Date, date.Format("Mon"))
+		for _, row := range day.Tasks {
+			fmt.Fprintf(w, "  %4d  %-40s %8s\n", row.ID, truncate(row.Title, 40), formatTracked(row.Tracked))
+		}
+		fmt.Fprintf(w, "  %-46s %8s\n", "Day total", formatTracked(day.Tracked))
+	}
+	fmt.Fprintln(w, "\nBy task")
+	for _, row := range sheet.Tasks {
+		fmt.Fprintf(w, "  %4d  %-40s %8s\n", row.ID, truncate(row.Title, 40), formatTracked(row.Tracked))
+	}
+	fmt.Fprintf(w, "  %-46s %8s\n", "Total", formatTracked(sheet.Tracked))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestTimesheet tests that tracked time is split by day and summed by task
+func TestTimesheet(t *testing.T) {
+	at := func(day, hour, minute int) *time.Time {
+		t := time.Date(2024, 6, day, hour, minute, 0, 0, time.Local)
+		return &t
+	}
+	now := *at(5, 10, 0)
+	tasks := []Task{
+		{ID: 1, Title: "Report", TimeEntries: []TimeEntry{
+			{Start: *at(3, 9, 0), End: at(3, 10, 30)},
+			{Start: *at(3, 23, 0), End: at(4, 1, 0)},
+		}},
+		{ID: 2, Title: "Review", TimeEntries: []TimeEntry{
+			{Start: *at(4, 14, 0), End: at(4, 14, 45)},
+			{Start: *at(5, 9, 0)},
+		}},
+		{ID: 3, Title: "Idle"},
+	}
+
+	sheet := buildTimesheet(tasks, *at(3, 0, 0), *at(5, 0, 0), now)
+
+	wantDays := map[string]int{"2024-06-03": 150, "2024-06-04": 105, "2024-06-05": 60}
+	if len(sheet.Days) != len(wantDays) {
+		t.Fatalf("timesheet has %d days; want %d", len(sheet.Days), len(wantDays))
+	}
+	for _, day := range sheet.Days {
+		if day.Minutes != wantDays[day.Date] {
+			t.Errorf("%s: %d minutes; want %d", day.Date, day.Minutes, wantDays[day.Date])
+		}
+	}
+
+	wantTasks := map[int]int{1: 210, 2: 105}
+	if len(sheet.Tasks) != len(wantTasks) {
+		t.Fatalf("timesheet has %d tasks; want %d", len(sheet.Tasks), len(wantTasks))
+	}
+	for _, row := range sheet.Tasks {
+		if row.Minutes != wantTasks[row.ID] {
+			t.Errorf("task %d: %d minutes; want %d", row.ID, row.Minutes, wantTasks[row.ID])
+		}
+	}
+	if sheet.Minutes != 315 {
+		t.Errorf("total = %d minutes; want 315", sheet.Minutes)
+	}
+
+	// A range that starts later leaves earlier work out
+	if later := buildTimesheet(tasks, *at(4, 0, 0), *at(4, 0, 0), now); later.Minutes != 105 {
+		t.Errorf("total for 2024-06-04 = %d minutes; want 105", later.Minutes)
+	}
+}
+
+// TestTimerOnTrashedTask tests that a timer left running on a trashed
+// task still counts as running and can be stopped, and that the time
+// tracked on it stays in the timesheet
+func TestTimerOnTrashedTask(t *testing.T) {
+	useFileStorage(t)
+	s, err := projectStore(defaultProject)
+	if err != nil {
+		t.Fatalf("projectStore() returned error: %v", err)
+	}
+	useStore(t, s)
+
+	start := time.Now().Add(-time.Hour)
+	trashed := &Task{Title: "Trashed", DeletedAt: &start, TimeEntries: []TimeEntry{{Start: start}}}
+	other := &Task{Title: "Other"}
+	for _, task := range []*Task{trashed, other} {
+		if err := createTask(task); err != nil {
+			t.Fatalf("createTask() returned error: %v", err)
+		}
+	}
+
+	if _, err := startTimer(other.ID); err == nil {
+		t.Errorf("startTimer() while a trashed task's timer runs returned no error")
+	}
+	stopped, err := stopTimer(0)
+	if err != nil || stopped.ID != trashed.ID || stopped.Running() {
+		t.Fatalf("stopTimer() = %+v, %v; want the trashed task stopped", stopped, err)
+	}
+
+	tasks, err := trackedTasks()
+	if err != nil {
+		t.Fatalf("trackedTasks() returned error: %v", err)
+	}
+	day := startOfDay(time.Now())
+	if sheet := buildTimesheet(tasks, day.AddDate(0, 0, -1), day, time.Now()); sheet.Minutes < 59 {
+		t.Errorf("timesheet total = %d minutes; want the hour tracked on the trashed task", sheet.Minutes)
+	}
+}
+
+// TestFormatTracked tests the hours and minutes format of tracked time
+func TestFormatTracked(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0h 00m"},
+		{45 * time.Minute, "0h 45m"},
+		{26*time.Hour + 5*time.Minute + 40*time.Second, "26h 06m"},
+	}
+	for _, tt := range tests {
+		if got := formatTracked(tt.d); got != tt.want {
+			t.Errorf("formatTracked(%v) = %q; want %q", tt.d, got, tt.want)
+		}
+	}
+}
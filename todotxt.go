@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+todo.txt keeps one task per line:
+
+	x 2024-06-14 2024-06-01 Call the bank +finance @phone due:2024-06-20
+
+A leading "x" marks a completed task, followed by its completion date.
+An open task may start with a priority such as (A). Then comes the
+creation date, and the title with +project, @context and key:value
+tokens mixed in. They map onto Task as follows:
+
+	(A) (B) (C) (D)-(Z)   urgent, high, medium, low; no priority is medium
+	pri:A                 the priority of a completed task
+	+project              the tag "project"
+	@context              the tag "@context"
+	due:2024-06-20        the due date; due:2024-06-20T17:00 with a time
+	rec:1d rec:2w rec:1m  daily, every 14 days, monthly on the due day;
+	                      rec:weekly:mon,thu and other rules also work
+	id:3 parent:3         subtasks, with IDs local to the file
+
+Every other token is kept in Task.Extra and written back on export, so
+a file survives a round trip through the task manager. So is the
+spelling of what the mapping loses: a priority letter such as (C) or
+(F) is kept as pri:C or pri:F and a tag such as +Groceries as itself,
+and both are written as the file had them for as long as the task's
+priority and tags still match. Descriptions are not part of the format.
+*/
+
+// todoDateLayout is how todo.txt writes dates
+const todoDateLayout = "2006-01-02"
+
+var (
+	todoPriorityPattern = regexp.MustCompile(`^\(([A-Z])\)$`)
+	// A key:value token has no spaces; URLs such as http://host are
+	// left in the title
+	todoKeyValuePattern = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9_-]*):([^/\s][^\s]*)$`)
+	todoRepeatPattern   = regexp.MustCompile(`^\+?(\d+)([dwm])$`)
+)
+
+// todoPriority returns the todo.txt priority letter of p; medium tasks
+// have none
+func todoPriority(p Priority) string {
+	switch p {
+	case PriorityUrgent:
+		return "A"
+	case PriorityHigh:
+		return "B"
+	case PriorityLow:
+		return "D"
+	}
+	return ""
+}
+
+// parseTodoPriority maps a todo.txt priority letter onto a priority
+func parseTodoPriority(letter string) Priority {
+	switch letter {
+	case "A":
+		return PriorityUrgent
+	case "B":
+		return PriorityHigh
+	case "C":
+		return PriorityMedium
+	}
+	return PriorityLow
+}
+
+// exportTodoTxt writes one todo.txt line per task
+func exportTodoTxt(w io.Writer, tasks []Task) error {
+	parents := map[int]bool{}
+	for _, task := range tasks {
+		if task.ParentID != 0 {
+			parents[task.ParentID] = true
+		}
+	}
+	for _, task := range tasks {
+		if _, err := fmt.Fprintln(w, todoLine(task, parents[task.ID])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// todoLine formats task as a todo.txt line. A task with subtasks gets
+// an id: token for them to refer to.
+func todoLine(task Task, hasSubtasks bool) string {
+	var words []string
+	letter, spellings, extra := todoSpellings(task.Extra)
+	priority := todoPriority(task.Priority)
+	if letter != "" && parseTodoPriority(letter) == task.Priority {
+		priority = letter
+	}
+	if task.Completed {
+		words = append(words, "x")
+		// The format only allows a creation date after a completion date
+		if task.CompletedAt != nil {
+			words = append(words, task.CompletedAt.Format(todoDateLayout))
+			if task.CreatedAt != nil {
+				words = append(words, task.CreatedAt.Format(todoDateLayout))
+			}
+		}
+	} else {
+		if priority != "" {
+			words = append(words, "("+priority+")")
+		}
+		if task.CreatedAt != nil {
+			words = append(words, task.CreatedAt.Format(todoDateLayout))
+		}
+	}
+
+	words = append(words, strings.Fields(task.Title)...)
+	for _, tag := range task.Tags {
+		switch spelling, ok := spellings[tag]; {
+		case ok:
+			words = append(words, spelling)
+		case strings.HasPrefix(tag, "@"):
+			words = append(words, tag)
+		default:
+			words = append(words, "+"+tag)
+		}
+	}
+	if task.DueDate != nil {
+		words = append(words, "due:"+todoDue(*task.DueDate))
+	}
+	if rule := todoRepeat(task); rule != "" {
+		words = append(words, "rec:"+rule)
+	}
+	if task.Completed && priority != "" {
+		words = append(words, "pri:"+priority)
+	}
+	if hasSubtasks {
+		words = append(words, fmt.Sprintf("id:%d", task.ID))
+	}
+	if task.ParentID != 0 {
+		words = append(words, fmt.Sprintf("parent:%d", task.ParentID))
+	}
+	words = append(words, extra...)
+	return strings.Join(words, " ")
+}
+
+// todoSpellings separates the priority letter and tag spellings
+// parseTodoLine keeps in Extra from the other tokens. Spellings are
+// keyed by the tag they spell.
+func todoSpellings(tokens []string) (letter string, spellings map[string]string, extra []string) {
+	spellings = map[string]string{}
+	for _, token := range tokens {
+		switch {
+		case strings.HasPrefix(token, "+") && len(token) > 1:
+			spellings[normalizeTag(token[1:])] = token
+		case strings.HasPrefix(token, "@") && len(token) > 1:
+			spellings[normalizeTag(token)] = token
+		case len(token) == 5 && strings.HasPrefix(token, "pri:") && token[4] >= 'A' && token[4] <= 'Z':
+			letter = token[4:]
+		default:
+			extra = append(extra, token)
+		}
+	}
+	return letter, spellings, extra
+}
+
+// todoDue formats a due date as a day, with the time of day unless the
+// task is due at the end of the day
+func todoDue(due time.Time) string {
+	if due.Equal(atClock(due, endOfDay)) {
+		return due.Format(todoDateLayout)
+	}
+	return due.Format("2006-01-02T15:04")
+}
+
+// todoRepeat writes the recurrence of task in the rec: notation other
+// todo.txt tools use where it has one
+func todoRepeat(task Task) string {
+	r := task.Recurrence
+	if r == nil {
+		return ""
+	}
+	switch {
+	case r.Kind == RepeatDaily:
+		return "1d"
+	case r.Kind == RepeatInterval:
+		return fmt.Sprintf("%dd", r.Interval)
+	case r.Kind == RepeatWeekly && len(r.Weekdays) == 0:
+		return "1w"
+	case r.Kind == RepeatMonthly && task.DueDate != nil && task.DueDate.Day() == r.Day:
+		return "1m"
+	}
+	return r.String()
+}
+
+// parseTodoRepeat reads a rec: value; ok is false for rules the task
+// manager cannot represent
+func parseTodoRepeat(value string, due *time.Time) (*Recurrence, bool) {
+	if m := todoRepeatPattern.FindStringSubmatch(value); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		switch {
+		case n == 0:
+			return nil, false
+		case m[2] == "d" && n == 1:
+			return &Recurrence{Kind: RepeatDaily}, true
+		case m[2] == "d":
+			return &Recurrence{Kind: RepeatInterval, Interval: n}, true
+		case m[2] == "w" && n == 1:
+			return &Recurrence{Kind: RepeatWeekly}, true
+		case m[2] == "w":
+			return &Recurrence{Kind: RepeatInterval, Interval: 7 * n}, true
+		case m[2] == "m" && n == 1 && due != nil:
+			return &Recurrence{Kind: RepeatMonthly, Day: due.Day()}, true
+		}
+		return nil, false
+	}
+	r, err := ParseRecurrence(value)
+	return r, err == nil
+}
+
+// parseTodoTxt reads a todo.txt file. Blank lines are skipped.
+func parseTodoTxt(r io.Reader) ([]importedTask, []importProblem, error) {
+	var imported []importedTask
+	var problems []importProblem
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		item, err := parseTodoLine(text)
+		if err != nil {
+			problems = append(problems, importProblem{Line: line, Reason: err.Error()})
+			continue
+		}
+		item.Line = line
+		imported = append(imported, item)
+	}
+	if err := scanner.Err(); err != nil {
+		return imported, problems, fmt.Errorf("error reading todo.txt: %v", err)
+	}
+	return imported, problems, nil
+}
+
+// parseTodoLine converts one todo.txt line into a task
+func parseTodoLine(text string) (importedTask, error) {
+	item := importedTask{Task: Task{Priority: PriorityMedium}}
+	task := &item.Task
+	words := strings.Fields(text)
+
+	if words[0] == "x" {
+		task.Completed = true
+		words = words[1:]
+	}
+	if len(words) > 0 {
+		if m := todoPriorityPattern.FindStringSubmatch(words[0]); m != nil {
+			task.Priority = parseTodoPriority(m[1])
+			keepTodoPriority(task, m[1])
+			words = words[1:]
+		}
+	}
+	var dates []time.Time
+	for len(words) > 0 && len(dates) < 2 {
+		date, err := time.ParseInLocation(todoDateLayout, words[0], time.Local)
+		if err != nil {
+			break
+		}
+		dates = append(dates, date)
+		words = words[1:]
+	}
+	switch {
+	case task.Completed && len(dates) > 0:
+		task.CompletedAt = &dates[0]
+		if len(dates) > 1 {
+			task.CreatedAt = &dates[1]
+		}
+	case len(dates) > 0:
+		task.CreatedAt = &dates[0]
+		if len(dates) > 1 {
+			// An open task has only a creation date; the second one is
+			// part of the title
+			words = append([]string{dates[1].Format(todoDateLayout)}, words...)
+		}
+	}
+
+	var title, repeat []string
+	for _, word := range words {
+		switch {
+		case len(word) > 1 && word[0] == '+':
+			task.AddTags(word[1:])
+			if normalizeTag(word[1:]) != word[1:] {
+				task.Extra = append(task.Extra, word)
+			}
+			continue
+		case len(word) > 1 && word[0] == '@':
+			task.AddTags(word)
+			if normalizeTag(word) != word {
+				task.Extra = append(task.Extra, word)
+			}
+			continue
+		}
+		m := todoKeyValuePattern.FindStringSubmatch(word)
+		if m == nil {
+			title = append(title, word)
+			continue
+		}
+		if !parseTodoKey(&item, m[1], m[2]) {
+			if m[1] == "rec" {
+				// Read once the due date is known
+				repeat = append(repeat, word)
+				continue
+			}
+			task.Extra = append(task.Extra, word)
+		}
+	}
+	for _, word := range repeat {
+		if r, ok := parseTodoRepeat(strings.TrimPrefix(word, "rec:"), task.DueDate); ok && task.Recurrence == nil {
+			task.Recurrence = r
+		} else {
+			task.Extra = append(task.Extra, word)
+		}
+	}
+
+	task.Title = strings.Join(title, " ")
+	if task.Title == "" {
+		return item, fmt.Errorf("title is empty")
+	}
+	return item, nil
+}
+
+// keepTodoPriority records a priority letter that does not map back
+// onto itself in task.Extra, for todoLine to write it as it was
+func keepTodoPriority(task *Task, letter string) {
+	if todoPriority(task.Priority) != letter {
+		task.Extra = append(task.Extra, "pri:"+letter)
+	}
+}
+
+// parseTodoKey applies a key:value token the task manager understands
+// and reports whether it did
+func parseTodoKey(item *importedTask, key, value string) bool {
+	task := &item.Task
+	switch key {
+	case "due":
+		if task.DueDate != nil {
+			return false
+		}
+		due, err := time.ParseInLocation(todoDateLayout, value, time.Local)
+		if err == nil {
+			due = atClock(due, endOfDay)
+		} else if due, err = time.ParseInLocation("2006-01-02T15:04", value, time.Local); err != nil {
+			return false
+		}
+		task.DueDate = &due
+		return true
+	case "pri":
+		if len(value) != 1 || value[0] < 'A' || value[0] > 'Z' {
+			return false
+		}
+		task.Priority = parseTodoPriority(value)
+		keepTodoPriority(task, value)
+		return true
+	case "id", "parent":
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return false
+		}
+		if key == "id" && item.SourceID == 0 {
+			item.SourceID = n
+			return true
+		}
+		if key == "parent" && item.ParentID == 0 {
+			item.ParentID = n
+			return true
+		}
+	}
+	return false
+}
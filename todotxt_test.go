@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestTodoTxtRoundTrip tests that todo.txt lines read and written again
+// come out unchanged, unknown tokens included
+func TestTodoTxtRoundTrip(t *testing.T) {
+	lines := []string{
+		"(A) 2024-06-01 Call the bank @phone +finance due:2024-06-20",
+		"x 2024-06-14 2024-06-01 File taxes due:2024-06-10T17:00 pri:B",
+		"Water plants rec:3d due:2024-06-03 rec:1w",
+		"(D) Read https://example.com/post key:value ref:42",
+		"Pay rent due:2024-07-01 rec:1m",
+		"Standup rec:weekly:mon,thu",
+		"(C) Buy milk +Groceries",
+		"(F) Later thing",
+		"x 2024-06-14 Sort papers @Home pri:E",
+	}
+	input := strings.Join(lines, "\n") + "\n"
+
+	items, problems, err := parseTodoTxt(strings.NewReader(input))
+	if err != nil || len(problems) > 0 {
+		t.Fatalf("parseTodoTxt() = %v, %v; want no problems", problems, err)
+	}
+	var tasks []Task
+	for _, item := range items {
+		tasks = append(tasks, item.Task)
+	}
+
+	// Tokens are written in a fixed order, so a line that had them in
+	// another order comes back reordered
+	lines[2] = "Water plants due:2024-06-03 rec:3d rec:1w"
+	var buf bytes.Buffer
+	if err := exportTodoTxt(&buf, tasks); err != nil {
+		t.Fatalf("exportTodoTxt() returned error: %v", err)
+	}
+	got := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(got) != len(lines) {
+		t.Fatalf("exportTodoTxt() wrote %d lines; want %d:\n%s", len(got), len(lines), buf.String())
+	}
+	for i := range lines {
+		if got[i] != lines[i] {
+			t.Errorf("line %d = %q; want %q", i+1, got[i], lines[i])
+		}
+	}
+}
+
+// TestParseTodoLine tests how todo.txt tokens map onto task fields
+func TestParseTodoLine(t *testing.T) {
+	item, err := parseTodoLine("x (B) 2024-06-14 Write report +Work @office id:7 parent:3 rec:2w")
+	if err != nil {
+		t.Fatalf("parseTodoLine() returned error: %v", err)
+	}
+	task := item.Task
+	if !task.Completed || task.CompletedAt == nil || task.CreatedAt != nil {
+		t.Errorf("completed = %v at %v, created %v; want completed on 2024-06-14", task.Completed, task.CompletedAt, task.CreatedAt)
+	}
+	if task.Priority != PriorityHigh {
+		t.Errorf("priority = %v; want high", task.Priority)
+	}
+	if task.Title != "Write report" {
+		t.Errorf("title = %q; want %q", task.Title, "Write report")
+	}
+	if strings.Join(task.Tags, ",") != "@office,work" {
+		t.Errorf("tags = %v; want [@office work]", task.Tags)
+	}
+	if item.SourceID != 7 || item.ParentID != 3 {
+		t.Errorf("id, parent = %d, %d; want 7, 3", item.SourceID, item.ParentID)
+	}
+	if task.Recurrence == nil || task.Recurrence.String() != "every 14 days" {
+		t.Errorf("recurrence = %v; want every 14 days", task.Recurrence)
+	}
+
+	// The spelling of the file is kept only while it still matches
+	item, err = parseTodoLine("(F) Plan trip +Travel +Summer")
+	if err != nil {
+		t.Fatalf("parseTodoLine() returned error: %v", err)
+	}
+	task = item.Task
+	task.Priority = PriorityHigh
+	task.RemoveTags("summer")
+	if got := todoLine(task, false); got != "(B) Plan trip +Travel" {
+		t.Errorf("todoLine() after changes = %q; want %q", got, "(B) Plan trip +Travel")
+	}
+
+	if _, err := parseTodoLine("(A) 2024-06-01 +tag due:2024-06-20"); err == nil {
+		t.Errorf("parseTodoLine() of a line without a title returned no error")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import "fmt"
+
+// treeNode is a task placed in the subtask tree
+type treeNode struct {
+	Task  Task
+	Depth int
+	// Done and Total count the task's completed and total descendants
+	Done, Total int
+	// Blockers are the open tasks this one waits for, set by markBlocked
+	Blockers []int
+}
+
+// childIndex groups tasks by parent ID, keeping their order
+func childIndex(tasks []Task) map[int][]Task {
+	children := map[int][]Task{}
+	for _, task := range tasks {
+		children[task.ParentID] = append(children[task.ParentID], task)
+	}
+	return children
+}
+
+// descendants returns every task below id, depth first
+func descendants(tasks []Task, id int) []Task {
+	children := childIndex(tasks)
+	var result []Task
+	var walk func(id int)
+	walk = func(id int) {
+		for _, child := range children[id] {
+			result = append(result, child)
+			walk(child.ID)
+		}
+	}
+	walk(id)
+	return result
+}
+
+// buildTree orders tasks depth first under their parents, keeping the
+// order of siblings. A task whose parent is not among tasks (for example
+// because a filter hid it) is shown at the top level.
+func buildTree(tasks []Task) []treeNode {
+	present := map[int]bool{}
+	for _, task := range tasks {
+		present[task.ID] = true
+	}
+	children := map[int][]Task{}
+	var roots []Task
+	for _, task := range tasks {
+		if task.ParentID != 0 && present[task.ParentID] {
+			children[task.ParentID] = append(children[task.ParentID], task)
+		} else {
+			roots = append(roots, task)
+		}
+	}
+
+	var nodes []treeNode
+	var walk func(task Task, depth int) (done, total int)
+	walk = func(task Task, depth int) (done, total int) {
+		index := len(nodes)
+		nodes = append(nodes, treeNode{Task: task, Depth: depth})
+		for _, child := range children[task.ID] {
+			childDone, childTotal := walk(child, depth+1)
+			done += childDone
+			total += childTotal + 1
+			if child.Completed {
+				done++
+			}
+		}
+		nodes[index].Done, nodes[index].Total = done, total
+		return done, total
+	}
+	for _, root := range roots {
+		walk(root, 0)
+	}
+	return nodes
+}
+
+// rollUp describes how many subtasks of a node are done, or returns ""
+// for tasks without subtasks
+func (n treeNode) rollUp() string {
+	if n.Total == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d subtasks done", n.Done, n.Total)
+}
+
+// validateParent checks that parentID may become the parent of task id:
+// the parent must exist and must not be the task itself or one of its
+// subtasks
+func validateParent(tasks []Task, id, parentID int) error {
+	if parentID == 0 {
+		return nil
+	}
+	if parentID == id {
+		return fmt.Errorf("a task cannot be its own parent")
+	}
+	found := false
+	for _, task := range tasks {
+		if task.ID == parentID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("parent task %d not found", parentID)
+	}
+	if id != 0 {
+		for _, task := range descendants(tasks, id) {
+			if task.ID == parentID {
+				return fmt.Errorf("task %d is a subtask of task %d", parentID, id)
+			}
+		}
+	}
+	return nil
+}
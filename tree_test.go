@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// treeTasks is a small subtask tree: Plan has two subtasks, one of them
+// with a subtask of its own, and Orphan's parent is missing
+var treeTasks = []Task{
+	{ID: 1, Title: "Plan"},
+	{ID: 2, Title: "Flights", ParentID: 1, Completed: true},
+	{ID: 3, Title: "Hotel", ParentID: 1},
+	{ID: 4, Title: "Booking ref", ParentID: 3, Completed: true},
+	{ID: 5, Title: "Orphan", ParentID: 99},
+	{ID: 6, Title: "Pack"},
+}
+
+// TestBuildTree tests the order, depth and roll-up of the tree, and that
+// tasks whose parent is missing are shown at the top level
+func TestBuildTree(t *testing.T) {
+	describe := func(nodes []treeNode) string {
+		var parts []string
+		for _, node := range nodes {
+			parts = append(parts, fmt.Sprintf("%d@%d:%d/%d", node.Task.ID, node.Depth, node.Done, node.Total))
+		}
+		return strings.Join(parts, " ")
+	}
+
+	tests := []struct {
+		name  string
+		tasks []Task
+		want  string
+	}{
+		{"all", treeTasks, "1@0:2/3 2@1:0/0 3@1:1/1 4@2:0/0 5@0:0/0 6@0:0/0"},
+		{"parent hidden", treeTasks[1:], "2@0:0/0 3@0:1/1 4@1:0/0 5@0:0/0 6@0:0/0"},
+		{"empty", nil, ""},
+	}
+	for _, tt := range tests {
+		if got := describe(buildTree(tt.tasks)); got != tt.want {
+			t.Errorf("buildTree() of %s = %s; want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestDescendants tests that every task below a task is found, depth
+// first
+func TestDescendants(t *testing.T) {
+	tests := []struct {
+		id   int
+		want []int
+	}{
+		{1, []int{2, 3, 4}},
+		{3, []int{4}},
+		{4, nil},
+		{99, []int{5}},
+	}
+	for _, tt := range tests {
+		var got []int
+		for _, task := range descendants(treeTasks, tt.id) {
+			got = append(got, task.ID)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("descendants(%d) = %v; want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+// TestValidateParent tests which parents a task may get
+func TestValidateParent(t *testing.T) {
+	tests := []struct {
+		id, parent int
+		want       string
+	}{
+		{3, 0, ""},
+		{4, 1, ""},
+		{5, 6, ""},
+		// A new task has no subtasks yet
+		{0, 4, ""},
+		{3, 3, "a task cannot be its own parent"},
+		{3, 42, "parent task 42 not found"},
+		{1, 4, "task 4 is a subtask of task 1"},
+		{3, 4, "task 4 is a subtask of task 3"},
+	}
+	for _, tt := range tests {
+		got := ""
+		if err := validateParent(treeTasks, tt.id, tt.parent); err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("validateParent(%d, %d) = %q; want %q", tt.id, tt.parent, got, tt.want)
+		}
+	}
+}
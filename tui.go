@@ -0,0 +1,610 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// errNoTerminal is returned by runTUI when the full-screen interface
+// cannot be used and the numbered menu should be shown instead
+var errNoTerminal = errors.New("standard input is not a terminal")
+
+// Escape sequences used by the full-screen interface
+const (
+	escAltScreenOn  = "\033[?1049h"
+	escAltScreenOff = "\033[?1049l"
+	escHideCursor   = "\033[?25l"
+	escShowCursor   = "\033[?25h"
+	escClearLine    = "\033[K"
+	escReverse      = "\033[7m"
+)
+
+// Keys returned by readKey besides printable runes
+const (
+	keyUp = -(iota + 1)
+	keyDown
+	keyPageUp
+	keyPageDown
+	keyHome
+	keyEnd
+	keyEnter
+	keyBackspace
+	keyEscape
+	keyCtrlC
+	keyUnknown
+)
+
+// sigwinch is the signal a terminal sends when it is resized. It has the
+// same number on Linux, macOS and the BSDs; syscall only names it on
+// those systems, and the interface is not used anywhere else.
+const sigwinch = syscall.Signal(0x1c)
+
+// tuiHelp is shown in the status bar when there is no message
+const tuiHelp = "↑/↓ move  space toggle  e edit  a add  d delete  t timer  s snooze  u undo  r redo  q quit"
+
+// tui is the state of the full-screen task list
+type tui struct {
+	nodes    []treeNode
+	selected int
+	offset   int
+	rows     int
+	cols     int
+	message  string
+	out      *bufio.Writer
+	// resized receives a signal when the terminal size has changed
+	resized chan os.Signal
+}
+
+// runTUI shows the full-screen task list until the user quits, showing
+// the reminders of scheduler in the status bar. It returns errNoTerminal
+// without touching the screen when stdin or stdout is not a terminal.
+func runTUI(scheduler *Scheduler) error {
+	if runtime.GOOS == "windows" || !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		return errNoTerminal
+	}
+	saved, err := stty("-g")
+	if err != nil {
+		return errNoTerminal
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return errNoTerminal
+	}
+	defer stty(strings.TrimSpace(saved))
+
+	// The size is read once and then only when the terminal says it has
+	// changed, rather than running stty on every redraw
+	t := &tui{out: bufio.NewWriter(os.Stdout), resized: make(chan os.Signal, 1)}
+	t.rows, t.cols = terminalSize()
+	signal.Notify(t.resized, sigwinch)
+	defer signal.Stop(t.resized)
+	t.out.WriteString(escAltScreenOn + escHideCursor)
+	defer func() {
+		t.out.WriteString(escShowCursor + escAltScreenOff)
+		t.out.Flush()
+	}()
+
+	if err := t.reload(); err != nil {
+		t.message = "Error: " + err.Error()
+	}
+
+	// Printed reminders would tear the screen, so they go to the status
+	// bar unless a notify command takes care of them
+	if _, ok := scheduler.notifier.(terminalNotifier); ok {
+		scheduler.notifier = t
+	}
+	scheduler.onError = func(err error) {
+		t.notice("Error: "+err.Error(), false)
+	}
+	scheduler.Start()
+	defer scheduler.Stop()
+
+	for {
+		storeLock.Lock()
+		t.draw()
+		storeLock.Unlock()
+
+		key := readKey()
+
+		storeLock.Lock()
+		quit := t.handle(key)
+		storeLock.Unlock()
+		if quit {
+			return nil
+		}
+	}
+}
+
+// handle acts on a key press and reports whether the user quit
+func (t *tui) handle(key int) bool {
+	t.message = ""
+	switch key {
+	case 'q', keyCtrlC:
+		return true
+	case keyUp, 'k':
+		t.move(-1)
+	case keyDown, 'j':
+		t.move(1)
+	case keyPageUp:
+		t.move(-t.pageSize())
+	case keyPageDown:
+		t.move(t.pageSize())
+	case keyHome:
+		t.move(-len(t.nodes))
+	case keyEnd:
+		t.move(len(t.nodes))
+	case ' ', 'x':
+		t.track("toggle", t.toggle)
+	case 'e', keyEnter:
+		t.track("edit", t.editTitle)
+	case 'a':
+		t.track("add", t.add)
+	case 'd':
+		t.track("delete", t.remove)
+	case 's':
+		t.snooze()
+	case 't':
+		t.track("timer", t.toggleTimer)
+	case 'u':
+		t.apply(history.UndoLast, "Undone")
+	case 'r':
+		t.apply(history.RedoLast, "Redone")
+	}
+	return false
+}
+
+// Notify shows a reminder in the status bar and rings the bell
+func (t *tui) Notify(r Reminder) error {
+	t.notice("Reminder: "+r.Message(time.Now()), true)
+	return nil
+}
+
+// notice shows message in the status bar from outside the key loop
+func (t *tui) notice(message string, bell bool) {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	if bell {
+		t.out.WriteString("\a")
+	}
+	t.message = message
+	t.draw()
+}
+
+// stty runs stty on the controlling terminal and returns its output
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// terminalSize returns the number of rows and columns of the terminal
+func terminalSize() (rows, cols int) {
+	out, err := stty("size")
+	if err == nil {
+		fields := strings.Fields(out)
+		if len(fields) == 2 {
+			rows, _ = strconv.Atoi(fields[0])
+			cols, _ = strconv.Atoi(fields[1])
+		}
+	}
+	if rows <= 0 || cols <= 0 {
+		return 24, 80
+	}
+	return rows, cols
+}
+
+// pendingKeys holds bytes read from the terminal but not yet turned
+// into keys, as when text is pasted or typed faster than it is handled
+var pendingKeys []byte
+
+// readKey reads one key press from the raw terminal
+func readKey() int {
+	if len(pendingKeys) == 0 {
+		var buf [64]byte
+		n, err := os.Stdin.Read(buf[:])
+		if err != nil || n == 0 {
+			return keyCtrlC
+		}
+		pendingKeys = append(pendingKeys, buf[:n]...)
+	}
+	key, size := parseKey(pendingKeys)
+	pendingKeys = pendingKeys[size:]
+	return key
+}
+
+// parseKey decodes the first key in b and returns it with the number of
+// bytes it used
+func parseKey(b []byte) (key, size int) {
+	switch {
+	case b[0] == 3:
+		return keyCtrlC, 1
+	case b[0] == '\r' || b[0] == '\n':
+		return keyEnter, 1
+	case b[0] == 127 || b[0] == 8:
+		return keyBackspace, 1
+	case b[0] == 27:
+		if len(b) < 3 || (b[1] != '[' && b[1] != 'O') {
+			return keyEscape, 1
+		}
+		// An escape sequence ends with a byte in the range '@' to '~'
+		for i := 2; i < len(b); i++ {
+			if b[i] < '@' || b[i] > '~' {
+				continue
+			}
+			switch string(b[2 : i+1]) {
+			case "A":
+				return keyUp, i + 1
+			case "B":
+				return keyDown, i + 1
+			case "H", "1~":
+				return keyHome, i + 1
+			case "F", "4~":
+				return keyEnd, i + 1
+			case "5~":
+				return keyPageUp, i + 1
+			case "6~":
+				return keyPageDown, i + 1
+			}
+			return keyUnknown, i + 1
+		}
+		return keyUnknown, len(b)
+	case b[0] < 32:
+		return keyUnknown, 1
+	}
+	r, size := utf8.DecodeRune(b)
+	return int(r), size
+}
+
+// reload reads the tasks again, keeping the selected task selected
+func (t *tui) reload() error {
+	selectedID := 0
+	if t.selected < len(t.nodes) {
+		selectedID = t.nodes[t.selected].Task.ID
+	}
+	tasks, err := store.List(ListOptions{})
+	if err != nil {
+		return err
+	}
+	all, err := allTasks()
+	if err != nil {
+		return err
+	}
+	t.nodes = buildTree(tasks)
+	markBlocked(t.nodes, all)
+	for i, node := range t.nodes {
+		if node.Task.ID == selectedID {
+			t.selected = i
+		}
+	}
+	if t.selected >= len(t.nodes) {
+		t.selected = len(t.nodes) - 1
+	}
+	if t.selected < 0 {
+		t.selected = 0
+	}
+	return nil
+}
+
+// pageSize is the number of task rows that fit between the title and
+// the status bar
+func (t *tui) pageSize() int {
+	if t.rows < 4 {
+		return 1
+	}
+	return t.rows - 3
+}
+
+// move changes the selection by delta rows, scrolling when needed
+func (t *tui) move(delta int) {
+	t.selected += delta
+	if t.selected >= len(t.nodes) {
+		t.selected = len(t.nodes) - 1
+	}
+	if t.selected < 0 {
+		t.selected = 0
+	}
+}
+
+// current returns the selected task, if there is one
+func (t *tui) current() *Task {
+	if t.selected >= len(t.nodes) {
+		return nil
+	}
+	task := t.nodes[t.selected].Task
+	return &task
+}
+
+// track runs an action as one undoable command and reloads the list
+func (t *tui) track(name string, action func() error) {
+	history.Begin(name)
+	err := action()
+	if endErr := history.End(); err == nil {
+		err = endErr
+	}
+	if err != nil {
+		t.message = "Error: " + err.Error()
+	}
+	if err := t.reload(); err != nil {
+		t.message = "Error: " + err.Error()
+	}
+}
+
+// apply runs undo or redo and reports the outcome
+func (t *tui) apply(step func() (*command, error), verb string) {
+	cmd, err := step()
+	if err != nil {
+		t.message = "Error: " + err.Error()
+	} else {
+		t.message = verb + ": " + cmd.describe()
+	}
+	if err := t.reload(); err != nil {
+		t.message = "Error: " + err.Error()
+	}
+}
+
+// toggle flips the completion of the selected task
+func (t *tui) toggle() error {
+	task := t.current()
+	if task == nil {
+		return nil
+	}
+	if task.Completed {
+		if err := workflow.CheckMove(workflow.StateOf(*task), workflow.Initial()); err != nil {
+			return fmt.Errorf("task %d cannot be reopened: %v", task.ID, err)
+		}
+		task.Completed = false
+		task.CompletedAt = nil
+		task.State = workflow.Initial()
+		t.message = fmt.Sprintf("Task %d reopened", task.ID)
+		return updateTask(task)
+	}
+	blockers := t.nodes[t.selected].Blockers
+	_, next, err := markCompleted(task.ID, false)
+	if err != nil {
+		return err
+	}
+	t.message = fmt.Sprintf("Task %d completed", task.ID)
+	if len(blockers) > 0 {
+		t.message += fmt.Sprintf(" although still blocked by %s", formatIDs(blockers))
+	}
+	if next != nil {
+		t.message += fmt.Sprintf("; next occurrence is task %d", next.ID)
+	}
+	return nil
+}
+
+// editTitle changes the title of the selected task in place
+func (t *tui) editTitle() error {
+	task := t.current()
+	if task == nil {
+		return nil
+	}
+	title, ok := t.prompt("Title: ", task.Title)
+	if !ok || title == task.Title {
+		return nil
+	}
+	if title == "" {
+		return fmt.Errorf("title cannot be empty")
+	}
+	task.Title = title
+	t.message = fmt.Sprintf("Task %d renamed", task.ID)
+	return updateTask(task)
+}
+
+// add creates a task below the selected one's parent
+func (t *tui) add() error {
+	title, ok := t.prompt("New task: ", "")
+	if !ok || title == "" {
+		return nil
+	}
+	task := Task{Title: title, Priority: PriorityMedium}
+	if current := t.current(); current != nil {
+		task.ParentID = current.ParentID
+	}
+	if err := createTask(&task); err != nil {
+		return err
+	}
+	t.message = fmt.Sprintf("Task %d added", task.ID)
+	if err := t.reload(); err != nil {
+		return err
+	}
+	for i, node := range t.nodes {
+		if node.Task.ID == task.ID {
+			t.selected = i
+		}
+	}
+	return nil
+}
+
+// toggleTimer starts or stops time tracking on the selected task
+func (t *tui) toggleTimer() error {
+	task := t.current()
+	if task == nil {
+		return nil
+	}
+	if task.Running() {
+		if _, err := stopTimer(task.ID); err != nil {
+			return err
+		}
+		t.message = fmt.Sprintf("Timer stopped for task %d", task.ID)
+		return nil
+	}
+	if _, err := startTimer(task.ID); err != nil {
+		return err
+	}
+	t.message = fmt.Sprintf("Timer started for task %d", task.ID)
+	return nil
+}
+
+// snooze puts off the reminders for the selected task
+func (t *tui) snooze() {
+	task := t.current()
+	if task == nil {
+		return
+	}
+	until, err := snoozeTask(task.ID, defaultSnooze)
+	if err != nil {
+		t.message = "Error: " + err.Error()
+		return
+	}
+	t.message = fmt.Sprintf("Task %d snoozed until %s", task.ID, until.Format("15:04"))
+}
+
+// remove moves the selected task to the trash after confirmation
+func (t *tui) remove() error {
+	task := t.current()
+	if task == nil {
+		return nil
+	}
+	answer, ok := t.prompt(fmt.Sprintf("Delete task %d? (y/N) ", task.ID), "")
+	if !ok || strings.ToLower(answer) != "y" {
+		return nil
+	}
+	if err := deleteWithSubtasks(task.ID, false); err != nil {
+		return err
+	}
+	t.message = fmt.Sprintf("Task %d moved to the trash", task.ID)
+	return nil
+}
+
+// prompt edits a line of text in the status bar. It returns false when
+// the user cancels with Escape.
+func (t *tui) prompt(label, value string) (string, bool) {
+	text := []rune(value)
+	for {
+		t.draw()
+		line := label + string(text)
+		fmt.Fprintf(t.out, "\033[%d;1H%s%s%s", t.rows, escClearLine, truncate(line, t.cols-1), escShowCursor)
+		t.out.Flush()
+
+		key := readKey()
+		switch {
+		case key == keyEnter:
+			t.out.WriteString(escHideCursor)
+			return strings.TrimSpace(string(text)), true
+		case key == keyEscape || key == keyCtrlC:
+			t.out.WriteString(escHideCursor)
+			return "", false
+		case key == keyBackspace:
+			if len(text) > 0 {
+				text = text[:len(text)-1]
+			}
+		case key >= ' ':
+			text = append(text, rune(key))
+		}
+	}
+}
+
+// draw renders the title, the visible part of the list and the status
+// bar
+func (t *tui) draw() {
+	select {
+	case <-t.resized:
+		t.rows, t.cols = terminalSize()
+	default:
+	}
+	page := t.pageSize()
+	if t.selected < t.offset {
+		t.offset = t.selected
+	}
+	if t.selected >= t.offset+page {
+		t.offset = t.selected - page + 1
+	}
+
+	done := 0
+	for _, node := range t.nodes {
+		if node.Task.Completed {
+			done++
+		}
+	}
+
+	now := time.Now()
+	fmt.Fprintf(t.out, "\033[1;1H%s%s", escClearLine, colorize(ansiBold, "Task Management System - project "+currentProject))
+	for row := 0; row < page; row++ {
+		fmt.Fprintf(t.out, "\033[%d;1H%s", row+3, escClearLine)
+		i := t.offset + row
+		if i >= len(t.nodes) {
+			if len(t.nodes) == 0 && row == 0 {
+				t.out.WriteString("No tasks yet. Press a to add one.")
+			}
+			continue
+		}
+		line := truncate(t.taskLine(t.nodes[i], now), t.cols)
+		switch {
+		case i == t.selected:
+			line = escReverse + line + ansiReset
+		case t.nodes[i].Task.IsOverdue(now):
+			line = ansiRed + line + ansiReset
+		}
+		t.out.WriteString(line)
+	}
+
+	status := t.message
+	if status == "" {
+		status = tuiHelp
+	}
+	status = fmt.Sprintf("%d/%d done | %s", done, len(t.nodes), status)
+	fmt.Fprintf(t.out, "\033[%d;1H%s%s%s%s", t.rows, escClearLine, escReverse,
+		padRight(truncate(status, t.cols), t.cols), ansiReset)
+	t.out.Flush()
+}
+
+// taskLine formats one row of the list
+func (t *tui) taskLine(node treeNode, now time.Time) string {
+	task := node.Task
+	mark := " "
+	if task.Completed {
+		mark = "x"
+	}
+	line := fmt.Sprintf("%4d [%s] %-6s %s%s", task.ID, mark, task.Priority,
+		strings.Repeat("  ", node.Depth), task.Title)
+	if node.Total > 0 {
+		line += " (" + node.rollUp() + ")"
+	}
+	if len(node.Blockers) > 0 {
+		line += "  BLOCKED by " + formatIDs(node.Blockers)
+	}
+	if task.DueDate != nil {
+		line += "  due " + formatDueDate(task.DueDate)
+		if task.IsOverdue(now) {
+			line += " OVERDUE"
+		}
+	}
+	if len(task.TimeEntries) > 0 {
+		line += "  " + formatTracked(task.Tracked(now))
+		if task.Running() {
+			line += " RUNNING"
+		}
+	}
+	return line
+}
+
+// truncate shortens s to at most width runes
+func truncate(s string, width int) string {
+	if width < 0 {
+		width = 0
+	}
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	return string(runes[:width])
+}
+
+// padRight pads s with spaces to width runes
+func padRight(s string, width int) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Workflow is the states tasks move through and the moves allowed
+// between them. New tasks start in the first state; tasks in one of the
+// Done states count as completed. Without Transitions, or with none
+// listed, a task can move from any state to any other; with them, only
+// to the states listed for its current one.
+type Workflow struct {
+	States      []string            `json:"states"`
+	Done        []string            `json:"done"`
+	Transitions map[string][]string `json:"transitions,omitempty"`
+}
+
+// defaultWorkflow is the workflow of a task list without a workflow
+// file: tasks are either open or completed
+var defaultWorkflow = Workflow{States: []string{"todo", "done"}, Done: []string{"done"}}
+
+// workflow is the workflow of every project, read from the file next to
+// the tasks
+var workflow = &defaultWorkflow
+
+var stateNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,19}$`)
+
+// loadWorkflow reads the workflow file at path; a missing file means the
+// default workflow
+func loadWorkflow(path string) (*Workflow, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &defaultWorkflow, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	var w Workflow
+	if err := json.Unmarshal(data, &w); err != nil {
+		return nil, fmt.Errorf("%s is corrupt: %v", path, err)
+	}
+	if err := w.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &w, nil
+}
+
+// validate checks that the states are well named and that the done
+// states and transitions only refer to them
+func (w *Workflow) validate() error {
+	if len(w.States) < 2 {
+		return fmt.Errorf("a workflow needs at least two states")
+	}
+	seen := map[string]bool{}
+	for _, state := range w.States {
+		if !stateNamePattern.MatchString(state) {
+			return fmt.Errorf("invalid state name %q (use up to 20 lowercase letters, digits, - and _)", state)
+		}
+		if seen[state] {
+			return fmt.Errorf("state %q is listed twice", state)
+		}
+		seen[state] = true
+	}
+	if len(w.Done) == 0 {
+		return fmt.Errorf("no state is marked as done")
+	}
+	for _, state := range w.Done {
+		if !seen[state] {
+			return fmt.Errorf("done state %q is not one of the states", state)
+		}
+	}
+	if w.IsDone(w.Initial()) {
+		return fmt.Errorf("the first state, where new tasks start, cannot be a done state")
+	}
+	for from, targets := range w.Transitions {
+		if !seen[from] {
+			return fmt.Errorf("transitions from unknown state %q", from)
+		}
+		for _, to := range targets {
+			if !seen[to] {
+				return fmt.Errorf("transition from %s to unknown state %q", from, to)
+			}
+		}
+	}
+	return nil
+}
+
+// Initial returns the state new and reopened tasks are in
+func (w *Workflow) Initial() string {
+	return w.States[0]
+}
+
+// Has reports whether state is one of the workflow's states
+func (w *Workflow) Has(state string) bool {
+	return containsString(w.States, state)
+}
+
+// IsDone reports whether tasks in state count as completed
+func (w *Workflow) IsDone(state string) bool {
+	return containsString(w.Done, state)
+}
+
+// StateOf returns the state task is in. A task without one, as those
+// saved before workflows were, is in the first done state when it is
+// completed and in the initial state otherwise.
+func (w *Workflow) StateOf(task Task) string {
+	switch {
+	case task.State != "":
+		return task.State
+	case task.Completed:
+		return w.Done[0]
+	}
+	return w.Initial()
+}
+
+// Next returns the states a task in state may move to, in workflow
+// order. A task in a state the workflow no longer has may move to any.
+func (w *Workflow) Next(state string) []string {
+	allowed := w.States
+	if len(w.Transitions) > 0 && w.Has(state) {
+		allowed = w.Transitions[state]
+	}
+	var next []string
+	for _, to := range w.States {
+		if to != state && containsString(allowed, to) {
+			next = append(next, to)
+		}
+	}
+	return next
+}
+
+// CheckMove returns an error unless a task may move from one state to
+// another
+func (w *Workflow) CheckMove(from, to string) error {
+	if !w.Has(to) {
+		return fmt.Errorf("unknown state %q (use %s)", to, strings.Join(w.States, ", "))
+	}
+	if from == to {
+		return fmt.Errorf("already %s", to)
+	}
+	next := w.Next(from)
+	if containsString(next, to) {
+		return nil
+	}
+	if len(next) == 0 {
+		return fmt.Errorf("cannot move from %s to %s; %s is final", from, to, from)
+	}
+	return fmt.Errorf("cannot move from %s to %s (allowed: %s)", from, to, strings.Join(next, ", "))
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// checkComplete returns an error unless the workflow lets an open task
+// move to the first done state, which completing a task does
+func checkComplete(task Task) error {
+	if task.Completed {
+		return nil
+	}
+	if err := workflow.CheckMove(workflow.StateOf(task), workflow.Done[0]); err != nil {
+		return fmt.Errorf("task %d cannot be completed: %v", task.ID, err)
+	}
+	return nil
+}
+
+// changeState moves task id to state to. Moving into a done state
+// completes the task, which for a recurring task adds its next
+// occurrence, returned as next; moving out of one reopens it and takes
+// it out of the archive, where no open task belongs.
+func changeState(id int, to string) (task, next *Task, err error) {
+	task, err = getTask(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := workflow.CheckMove(workflow.StateOf(*task), to); err != nil {
+		return nil, nil, fmt.Errorf("task %d: %v", id, err)
+	}
+	task.State = to
+	switch done := workflow.IsDone(to); {
+	case done && !task.Completed:
+		next, err = completeOne(task, time.Now())
+		return task, next, err
+	case !done:
+		task.Completed = false
+		task.CompletedAt = nil
+		task.ArchivedAt = nil
+	}
+	return task, nil, updateTask(task)
+}
+
+// changeSelectedState moves tasks to state to in one step. Tasks that
+// are in it already are skipped. It returns the moved tasks and the next
+// occurrences of recurring tasks it completed.
+func changeSelectedState(tasks []Task, to string) (moved, next []Task, err error) {
+	err = atomically(func() error {
+		for _, selected := range tasks {
+			task, err := getTask(selected.ID)
+			if err != nil {
+				return err
+			}
+			if workflow.StateOf(*task) == to {
+				continue
+			}
+			task, n, err := changeState(task.ID, to)
+			if err != nil {
+				return err
+			}
+			moved = append(moved, *task)
+			if n != nil {
+				next = append(next, *n)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return moved, next, nil
+}
+
+// boardColumn is one state of the board with the tasks in it
+type boardColumn struct {
+	State string `json:"state"`
+	Tasks []Task `json:"tasks"`
+}
+
+// boardColumns groups tasks by state, one column per workflow state in
+// workflow order, followed by states tasks are in that the workflow no
+// longer has
+func boardColumns(tasks []Task) []boardColumn {
+	var columns []boardColumn
+	index := map[string]int{}
+	for _, state := range workflow.States {
+		index[state] = len(columns)
+		columns = append(columns, boardColumn{State: state, Tasks: []Task{}})
+	}
+	for _, task := range tasks {
+		state := workflow.StateOf(task)
+		i, ok := index[state]
+		if !ok {
+			i = len(columns)
+			index[state] = i
+			columns = append(columns, boardColumn{State: state, Tasks: []Task{}})
+		}
+		columns[i].Tasks = append(columns[i].Tasks, task)
+	}
+	return columns
+}
+
+// printBoard writes columns side by side, one card line per task,
+// fitted into width characters
+func printBoard(w io.Writer, columns []boardColumn, width int, now time.Time) {
+	if len(columns) == 0 {
+		return
+	}
+	colWidth := (width - 3*(len(columns)-1)) / len(columns)
+	if colWidth < 12 {
+		colWidth = 12
+	}
+	rows := 0
+	for _, column := range columns {
+		if len(column.Tasks) > rows {
+			rows = len(column.Tasks)
+		}
+	}
+
+	line := func(cells []string) {
+		fmt.Fprintln(w, strings.TrimRight(strings.Join(cells, " | "), " "))
+	}
+	cells := make([]string, len(columns))
+	for i, column := range columns {
+		cells[i] = padRight(truncate(fmt.Sprintf("%s (%d)", strings.ToUpper(column.State), len(column.Tasks)), colWidth), colWidth)
+	}
+	line(cells)
+	for i := range columns {
+		cells[i] = strings.Repeat("-", colWidth)
+	}
+	line(cells)
+	for row := 0; row < rows; row++ {
+		for i, column := range columns {
+			card := ""
+			if row < len(column.Tasks) {
+				card = boardCard(column.Tasks[row], now)
+			}
+			cells[i] = padRight(truncate(card, colWidth), colWidth)
+		}
+		line(cells)
+	}
+}
+
+// boardCard summarises a task in one short line, marking urgent and
+// overdue ones
+func boardCard(task Task, now time.Time) string {
+	card := fmt.Sprintf("%d %s", task.ID, task.Title)
+	if task.Priority == PriorityUrgent {
+		card = "!" + card
+	}
+	if task.IsOverdue(now) {
+		card += " (overdue)"
+	}
+	return card
+}
+
+// printWorkflow describes the states and the moves between them
+func printWorkflow(w io.Writer) {
+	for _, state := range workflow.States {
+		kind := ""
+		switch {
+		case state == workflow.Initial():
+			kind = " (initial)"
+		case workflow.IsDone(state):
+			kind = " (done)"
+		}
+		next := workflow.Next(state)
+		moves := "final"
+		if len(next) > 0 {
+			moves = "-> " + strings.Join(next, ", ")
+		}
+		fmt.Fprintf(w, "%-22s %s\n", state+kind, moves)
+	}
+}
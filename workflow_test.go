@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reviewWorkflow is a workflow with a review step and limited moves
+var reviewWorkflow = Workflow{
+	States: []string{"todo", "doing", "review", "done", "dropped"},
+	Done:   []string{"done", "dropped"},
+	Transitions: map[string][]string{
+		"todo":   {"doing", "dropped"},
+		"doing":  {"review", "todo"},
+		"review": {"done", "doing"},
+		"done":   {"todo"},
+	},
+}
+
+// TestWorkflowCheckMove tests which moves a workflow allows
+func TestWorkflowCheckMove(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     string
+	}{
+		{"todo", "doing", ""},
+		{"todo", "done", "cannot move from todo to done (allowed: doing, dropped)"},
+		{"review", "done", ""},
+		{"done", "todo", ""},
+		{"doing", "doing", "already doing"},
+		{"dropped", "todo", "cannot move from dropped to todo; dropped is final"},
+		{"todo", "blocked", `unknown state "blocked" (use todo, doing, review, done, dropped)`},
+		// A state the workflow no longer has can be left for any other
+		{"waiting", "review", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+" to "+tt.to, func(t *testing.T) {
+			got := ""
+			if err := reviewWorkflow.CheckMove(tt.from, tt.to); err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("CheckMove(%q, %q) = %q; want %q", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+
+	if err := defaultWorkflow.CheckMove("todo", "done"); err != nil {
+		t.Errorf("default workflow refused todo to done: %v", err)
+	}
+	// An empty list of transitions restricts nothing, as none does
+	open := Workflow{States: []string{"todo", "done"}, Done: []string{"done"}, Transitions: map[string][]string{}}
+	if err := open.CheckMove("done", "todo"); err != nil {
+		t.Errorf("workflow with empty transitions refused done to todo: %v", err)
+	}
+}
+
+// TestWorkflowStateOf tests the state of tasks saved without one
+func TestWorkflowStateOf(t *testing.T) {
+	tests := []struct {
+		task Task
+		want string
+	}{
+		{Task{}, "todo"},
+		{Task{Completed: true}, "done"},
+		{Task{State: "review"}, "review"},
+		{Task{State: "dropped", Completed: true}, "dropped"},
+	}
+	for _, tt := range tests {
+		if got := reviewWorkflow.StateOf(tt.task); got != tt.want {
+			t.Errorf("StateOf(%+v) = %q; want %q", tt.task, got, tt.want)
+		}
+	}
+}
+
+// TestLoadWorkflow tests that workflow files are checked when read
+func TestLoadWorkflow(t *testing.T) {
+	dir := t.TempDir()
+	w, err := loadWorkflow(filepath.Join(dir, "missing"))
+	if err != nil || strings.Join(w.States, ",") != "todo,done" {
+		t.Errorf("loadWorkflow() of a missing file = %+v, %v; want the default workflow", w, err)
+	}
+
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"valid", `{"states": ["todo", "doing", "done"], "done": ["done"]}`, ""},
+		{"one state", `{"states": ["todo"], "done": ["todo"]}`, "at least two states"},
+		{"bad name", `{"states": ["todo", "In Review"], "done": ["todo"]}`, "invalid state name"},
+		{"no done", `{"states": ["todo", "done"]}`, "no state is marked as done"},
+		{"done first", `{"states": ["done", "todo"], "done": ["done"]}`, "cannot be a done state"},
+		{"unknown target", `{"states": ["todo", "done"], "done": ["done"], "transitions": {"todo": ["shipped"]}}`, `unknown state "shipped"`},
+		{"no transitions", `{"states": ["todo", "done"], "done": ["done"], "transitions": {}}`, ""},
+		{"corrupt", `{"states": `, "is corrupt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			if err := ioutil.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := loadWorkflow(path)
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("loadWorkflow() returned error: %v", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("loadWorkflow() = %v; want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestChangeState tests that moving into a done state completes a task
+// and moving out of one reopens it
+func TestChangeState(t *testing.T) {
+	useStore(t, NewMemoryStore())
+	saved := workflow
+	workflow = &reviewWorkflow
+	defer func() { workflow = saved }()
+
+	// Due in an hour, so the next occurrence is due a day after it
+	due := time.Now().Add(time.Hour).Truncate(time.Minute)
+	task := &Task{Title: "Water plants", State: "review", DueDate: &due, Recurrence: &Recurrence{Kind: RepeatDaily}}
+	if err := createTask(task); err != nil {
+		t.Fatalf("createTask() returned error: %v", err)
+	}
+
+	moved, next, err := changeState(task.ID, "done")
+	if err != nil {
+		t.Fatalf("changeState() to done returned error: %v", err)
+	}
+	if moved.State != "done" || !moved.Completed || moved.CompletedAt == nil {
+		t.Errorf("task after moving to done = %q, completed %v at %v; want done and completed", moved.State, moved.Completed, moved.CompletedAt)
+	}
+	if next == nil || next.Completed || next.State != "" || next.DueDate == nil || !next.DueDate.Equal(due.AddDate(0, 0, 1)) {
+		t.Errorf("next occurrence = %+v; want an open task due a day later", next)
+	}
+
+	moved, next, err = changeState(task.ID, "todo")
+	if err != nil {
+		t.Fatalf("changeState() to todo returned error: %v", err)
+	}
+	if moved.State != "todo" || moved.Completed || moved.CompletedAt != nil || next != nil {
+		t.Errorf("task after moving to todo = %q, completed %v at %v; want todo and open", moved.State, moved.Completed, moved.CompletedAt)
+	}
+	if stored, _ := getTask(task.ID); stored.Completed || stored.State != "todo" {
+		t.Errorf("stored task = %q, completed %v; want todo and open", stored.State, stored.Completed)
+	}
+
+	if _, _, err := changeState(task.ID, "done"); err == nil {
+		t.Errorf("changeState() from todo to done returned no error")
+	}
+}
+
+// TestChangeStateOfCompletedTask tests moves between two done states and
+// reopening an archived task
+func TestChangeStateOfCompletedTask(t *testing.T) {
+	useStore(t, NewMemoryStore())
+	saved := workflow
+	workflow = &reviewWorkflow
+	defer func() { workflow = saved }()
+
+	completedAt := time.Date(2024, 6, 10, 9, 0, 0, 0, time.Local)
+	archivedAt := completedAt.AddDate(0, 0, 1)
+	task := &Task{Title: "Old report", State: "review"}
+	if err := createTask(task); err != nil {
+		t.Fatalf("createTask() returned error: %v", err)
+	}
+	if _, _, err := changeState(task.ID, "done"); err != nil {
+		t.Fatalf("changeState() to done returned error: %v", err)
+	}
+
+	// done to dropped is not allowed by reviewWorkflow, so a workflow
+	// that allows any move is used for it
+	workflow = &Workflow{States: reviewWorkflow.States, Done: reviewWorkflow.Done}
+	stored, _ := getTask(task.ID)
+	stored.CompletedAt = &completedAt
+	if err := updateTask(stored); err != nil {
+		t.Fatalf("updateTask() returned error: %v", err)
+	}
+	if _, _, err := changeState(task.ID, "dropped"); err != nil {
+		t.Fatalf("changeState() to dropped returned error: %v", err)
+	}
+	stored, _ = getTask(task.ID)
+	if stored.State != "dropped" || !stored.Completed || stored.CompletedAt == nil || !stored.CompletedAt.Equal(completedAt) {
+		t.Errorf("stored task = %q, completed %v at %v; want dropped, completed at %s", stored.State, stored.Completed, stored.CompletedAt, completedAt)
+	}
+
+	stored.ArchivedAt = &archivedAt
+	if err := updateTask(stored); err != nil {
+		t.Fatalf("updateTask() returned error: %v", err)
+	}
+	if _, _, err := changeState(task.ID, "doing"); err != nil {
+		t.Fatalf("changeState() to doing returned error: %v", err)
+	}
+	stored, _ = getTask(task.ID)
+	if stored.State != "doing" || stored.Completed || stored.ArchivedAt != nil {
+		t.Errorf("reopened task = %q, completed %v, archived %v; want doing, open and not archived", stored.State, stored.Completed, stored.ArchivedAt)
+	}
+	if open, _ := store.List(ListOptions{}); len(open) != 1 {
+		t.Errorf("default listing shows %d tasks; want the reopened task", len(open))
+	}
+}
+
+// TestMarkCompletedTwice tests that completing a completed task keeps
+// the time it was completed at and adds no second occurrence
+func TestMarkCompletedTwice(t *testing.T) {
+	useStore(t, NewMemoryStore())
+	completedAt := time.Date(2024, 6, 10, 9, 0, 0, 0, time.Local)
+	task := &Task{Title: "Report", Completed: true, CompletedAt: &completedAt, State: "done", Recurrence: &Recurrence{Kind: RepeatDaily}}
+	if err := createTask(task); err != nil {
+		t.Fatalf("createTask() returned error: %v", err)
+	}
+	got, next, err := markCompleted(task.ID, false)
+	if err != nil {
+		t.Fatalf("markCompleted() returned error: %v", err)
+	}
+	if next != nil || got.CompletedAt == nil || !got.CompletedAt.Equal(completedAt) {
+		t.Errorf("markCompleted() = completed at %v with next %+v; want %s and none", got.CompletedAt, next, completedAt)
+	}
+	if stored, _ := getTask(task.ID); !stored.CompletedAt.Equal(completedAt) {
+		t.Errorf("stored completion time = %s; want %s", stored.CompletedAt, completedAt)
+	}
+}
+
+// TestChangeSelectedState tests that a move the workflow refuses for one
+// task of a selection leaves every task as it was
+func TestChangeSelectedState(t *testing.T) {
+	useStore(t, NewMemoryStore())
+	saved := workflow
+	workflow = &reviewWorkflow
+	defer func() { workflow = saved }()
+
+	tasks := []Task{
+		{Title: "Reviewed", State: "review", Recurrence: &Recurrence{Kind: RepeatDaily}},
+		{Title: "Done already", State: "done", Completed: true},
+		{Title: "Not started"},
+	}
+	for i := range tasks {
+		if err := createTask(&tasks[i]); err != nil {
+			t.Fatalf("createTask() returned error: %v", err)
+		}
+	}
+
+	if _, _, err := changeSelectedState(tasks, "done"); err == nil {
+		t.Fatalf("changeSelectedState() returned no error")
+	}
+	all, _ := allTasks()
+	if len(all) != len(tasks) {
+		t.Errorf("changeSelectedState() left %d tasks; want %d", len(all), len(tasks))
+	}
+	for i, task := range all {
+		if task.State != tasks[i].State || task.Completed != tasks[i].Completed {
+			t.Errorf("task %d = %q, completed %v; want %q, completed %v", task.ID, task.State, task.Completed, tasks[i].State, tasks[i].Completed)
+		}
+	}
+
+	moved, next, err := changeSelectedState(tasks[:2], "done")
+	if err != nil {
+		t.Fatalf("changeSelectedState() returned error: %v", err)
+	}
+	if len(moved) != 1 || moved[0].Title != "Reviewed" || len(next) != 1 {
+		t.Errorf("changeSelectedState() moved %+v with next %+v; want Reviewed and its next occurrence", moved, next)
+	}
+}
+
+// TestPrintBoard tests that tasks are grouped into a column per state
+func TestPrintBoard(t *testing.T) {
+	saved := workflow
+	workflow = &reviewWorkflow
+	defer func() { workflow = saved }()
+
+	tasks := []Task{
+		{ID: 1, Title: "Write spec", Completed: true},
+		{ID: 2, Title: "Build the importer", State: "doing", Priority: PriorityUrgent},
+		{ID: 3, Title: "Ship"},
+		{ID: 4, Title: "Old", State: "waiting"},
+	}
+	columns := boardColumns(tasks)
+	var states []string
+	for _, column := range columns {
+		states = append(states, column.State)
+	}
+	if got := strings.Join(states, ","); got != "todo,doing,review,done,dropped,waiting" {
+		t.Fatalf("columns = %s; want the workflow states, then waiting", got)
+	}
+
+	var buf bytes.Buffer
+	printBoard(&buf, columns[:4], 60, time.Now())
+	want := []string{
+		"TODO (1)     | DOING (1)    | REVIEW (0)   | DONE (1)",
+		"------------ | ------------ | ------------ | ------------",
+		"3 Ship       | !2 Build the |              | 1 Write spec",
+	}
+	got := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("printBoard() wrote\n%s\nwant\n%s", buf.String(), strings.Join(want, "\n"))
+	}
+}